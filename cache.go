@@ -1,17 +1,26 @@
 package simplecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand"
 	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type entry[K comparable, V any] struct {
 	Expiration int64
-	sync.Mutex
-	key   K
-	value V
+	createdAt  int64
+	lastAccess int64  // updated atomically, see Get
+	hits       uint64 // updated atomically, see GetWithHits
+	gen        uint64
+	key        K
+	value      V
 }
 
 func (e *entry[K, V]) Expired() bool {
@@ -30,6 +39,30 @@ const (
 	DefaultExpiration time.Duration = 0
 )
 
+// OverflowPolicy decides what happens when a new key is added to a cache that
+// is full (see WithMaxItems) and has no expired items to reclaim among the
+// ones sampled.
+type OverflowPolicy int
+
+const (
+	// EvictOldest evicts the oldest sampled item to make room. This is the
+	// default.
+	EvictOldest OverflowPolicy = iota
+	// RejectNew keeps the existing items and drops the new one.
+	RejectNew
+)
+
+// ErrCapacity is returned by Add and SetValidated when the cache is full and
+// its overflow policy is RejectNew.
+var ErrCapacity = errors.New("simplecache: cache is full")
+
+// ErrClosed is returned by Add, SetValidated and the other writes that return
+// an error once the cache has been closed, see Close.
+var ErrClosed = errors.New("simplecache: cache is closed")
+
+// ErrNotFound is returned by GetErr when the key is not in the cache.
+var ErrNotFound = errors.New("simplecache: key not found")
+
 type Cache[K comparable, V any] struct {
 	*cache[K, V]
 	// If this is confusing, see the comment at the bottom of New()
@@ -37,162 +70,1194 @@ type Cache[K comparable, V any] struct {
 
 type cache[K comparable, V any] struct {
 	sync.RWMutex
+	id                uint64 // orders locking when two caches are locked together
+	name              string
 	defaultExpiration time.Duration
+	maxTTL            time.Duration
+	maxItems          int
+	overflow          OverflowPolicy
+	evictionSamples   int
+	trackAccess       bool
+	idleExpiration    time.Duration
+	initcap           int
+	gen               uint64 // current generation, see Invalidate
 	items             []entry[K, V]
-	indices           map[K]int
+	indices           map[K]int // nil while scanning, see WithLinearScan
+	scanMax           int
 	onEvicted         func(K, V)
+	onMiss            func(K)
+	errorHandler      func(error)
+	evictOnGet        bool
+	logger            Logger // nil means no logging, see WithLogger
 	stop              chan struct{}
+	manualLifecycle   bool          // no finalizer, see WithManualLifecycle
+	cleanupMin        time.Duration // adaptive janitor bounds, see WithAdaptiveCleanup
+	cleanupMax        time.Duration
+	cleanupBatch      int // items examined per write lock, see WithCleanupBatch
+	closeOnce         sync.Once
+	closed            bool // writes are refused, see Close
+	now               func() int64
+	ttlHist           []int64 // see WithTTLHistogram
+	peak              int     // most items held at once, see PeakLen
+
+	// Value transformations applied when storing and loading items, see
+	// WithCompression.
+	encode      func(V) (V, error)
+	decode      func(V) (V, error)
+	rawBytes    int64
+	storedBytes int64
+
+	// Deep-copies values on the way in, and on the way out if copyOnGet is
+	// set, see WithValueCopier.
+	copyValue func(V) V
+	copyOnGet bool
+
+	// Checks every write, see WithWriteValidator.
+	validate func(K, V) error
+
+	// Compares values in CompareAndSwap and friends, see WithEquality.
+	equal func(a, b V) bool
+
+	// Serve-stale support, see WithServeStale.
+	staleFor   time.Duration
+	loader     func(K) (V, error)
+	refreshMu  sync.Mutex
+	refreshing map[K]struct{}
+
+	// Items evicted to make room under the write lock, waiting for onEvicted
+	// to be called once it is released, see unlock.
+	evictedKeys   []K
+	evictedValues []V
+
+	// Queue of onEvicted calls, see WithAsyncEvictions.
+	evictQueue       chan evictedItem[K, V]
+	evictMu          sync.RWMutex // held for writing by the final drain
+	dropEvictions    bool
+	droppedEvictions int64
+
+	// In-flight loads, see LoadOrStoreFn.
+	loadMu sync.Mutex
+	loads  map[K]*loadCall[V]
+
+	// Reverse index for grouped invalidation, see SetWithTags.
+	tags    map[string]map[K]struct{}
+	keyTags map[K][]string
+
+	// Channels returned by Watch, by key.
+	watchers map[K][]chan V
+}
+
+// Option configures optional behaviour of a cache created by New.
+type Option[K comparable, V any] func(*cache[K, V])
+
+// WithServeStale keeps expired items servable by Get for an additional
+// staleFor window. A Get that hits a stale item returns the old value
+// immediately and refreshes it in the background by calling loader; if
+// loader returns an error the stale value is kept. Once staleFor has also
+// elapsed the item is treated as missing.
+func WithServeStale[K comparable, V any](staleFor time.Duration, loader func(K) (V, error)) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.staleFor = staleFor
+		c.loader = loader
+		c.refreshing = make(map[K]struct{})
+	}
+}
+
+// WithName tags the cache with a name, for telling caches apart in logs and
+// metrics. It has no effect on the cache's behaviour.
+func WithName[K comparable, V any](name string) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.name = name
+	}
+}
+
+// WithMaxTTL caps the lifetime of every item at max. Items set with a longer
+// duration, or with NoExpiration, expire after max instead.
+func WithMaxTTL[K comparable, V any](max time.Duration) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.maxTTL = max
+	}
+}
+
+// WithMaxItems bounds the cache to max items. When a new key is added to a
+// full cache, a sample of items is looked at (see WithEvictionSamples) and
+// the expired ones among them are removed to make room if there are any;
+// otherwise the oldest sampled item (the one that was set longest ago) is
+// evicted, unless WithOverflowPolicy says to reject the new key instead.
+// The onEvicted callback is called for items removed this way.
+func WithMaxItems[K comparable, V any](max int) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.maxItems = max
+	}
+}
+
+// defaultEvictionSamples is the sample size used without WithEvictionSamples.
+const defaultEvictionSamples = 16
+
+// WithEvictionSamples sets how many items, picked at random, are looked at
+// when a new key is added to a full cache (see WithMaxItems). Sampling keeps
+// the cost of such inserts independent of the cache size, at the price of
+// evicting an old item rather than the oldest one, and under RejectNew of
+// refusing a key while an expired item outside the sample still holds a
+// slot. A cache holding at most n items is always scanned in full. The
+// default is 16; n <= 0 restores it.
+func WithEvictionSamples[K comparable, V any](n int) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.evictionSamples = n
+	}
+}
+
+// WithOverflowPolicy sets what happens when a new key is added to a full
+// cache. It only has an effect together with WithMaxItems.
+func WithOverflowPolicy[K comparable, V any](p OverflowPolicy) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.overflow = p
+	}
+}
+
+// WithEvictOnGet makes Get delete an item it finds expired (beyond any stale
+// period), idle or invalidated right away, calling onEvicted, instead of
+// leaving it for the janitor. This frees the memory of expired items that are
+// still being asked for sooner, at the cost of a write lock on such misses.
+func WithEvictOnGet[K comparable, V any](evict bool) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.evictOnGet = evict
+	}
+}
+
+// WithErrorHandler sets a function that is called with errors the cache
+// cannot return to a caller, such as a panic recovered from an onEvicted
+// callback or a value that cannot be decompressed (see WithCompression). By
+// default such errors are written to the standard logger. f may be called
+// with the cache's lock held, as by Foreach, so it must not use the cache.
+func WithErrorHandler[K comparable, V any](f func(error)) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.errorHandler = f
+	}
+}
+
+// WithOnEvicted sets the onEvicted callback (see OnEvicted) before the
+// janitor starts, so no eviction can happen without it.
+func WithOnEvicted[K comparable, V any](f func(K, V)) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.onEvicted = f
+	}
+}
+
+// WithAccessTracking makes reads record when each item was last read, for use
+// by IdleTime. It costs a clock read and an atomic store on every read.
+func WithAccessTracking[K comparable, V any]() Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.trackAccess = true
+	}
+}
+
+// WithIdleExpiration expires items that have not been read with Get for d,
+// independently of their absolute expiration time. Idle items are treated as
+// missing by Get, Contains and Add, and are deleted by the janitor. It
+// enables access tracking (see WithAccessTracking).
+func WithIdleExpiration[K comparable, V any](d time.Duration) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.idleExpiration = d
+		c.trackAccess = true
+	}
+}
+
+// WithAdaptiveCleanup makes the janitor adapt its interval to how many items
+// expire: each pass that finds nothing to delete doubles the interval, and
+// each pass that deletes at least a quarter of the items halves it, within
+// [min, max]. The first pass runs after the cleanup interval given to New,
+// clamped to those bounds; the janitor is started even if that interval is
+// less than one, in which case the first pass runs after max.
+func WithAdaptiveCleanup[K comparable, V any](min, max time.Duration) Option[K, V] {
+	return func(c *cache[K, V]) {
+		if min <= 0 {
+			min = time.Millisecond
+		}
+		if max < min {
+			max = min
+		}
+		c.cleanupMin, c.cleanupMax = min, max
+	}
+}
+
+// WithCleanupBatch makes DeleteExpired, and the janitor, release the write
+// lock and take it again after examining every n items, so that reads and
+// writes are not blocked for the whole of a cleanup of a very large cache.
+// The cleanup then takes longer, and items set while it has released the lock
+// may or may not be examined by it.
+func WithCleanupBatch[K comparable, V any](n int) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.cleanupBatch = n
+	}
+}
+
+// WithValueCopier makes the cache store a copy of every value it is given,
+// made with copier, so that a caller mutating a slice, map or pointer value
+// after setting it does not change the cached value. If onGet is true, values
+// are copied again when they are read, so that callers cannot mutate the
+// cached value through what they get back either. GetPointer still points at
+// the stored value.
+func WithValueCopier[K comparable, V any](copier func(V) V, onGet bool) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.copyValue = copier
+		c.copyOnGet = onGet
+	}
+}
+
+// WithWriteValidator sets a function that checks every write to the cache,
+// such as Set, Add and SetWithTags, before it is made. A write for which it
+// returns an error is dropped; Add and SetValidated return the error. It is
+// called with the cache's write lock held, so it must not use the cache.
+func WithWriteValidator[K comparable, V any](validate func(K, V) error) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.validate = validate
+	}
+}
+
+// WithManualLifecycle skips the finalizer that normally stops the janitor once
+// the cache is garbage collected, which saves its setup cost and keeps
+// benchmarks and GC profiles free of finalizer work. The caller must call
+// Close when done with the cache: without it the janitor goroutine, and the
+// cache it references, are never freed.
+func WithManualLifecycle[K comparable, V any]() Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.manualLifecycle = true
+	}
+}
+
+// lastCacheID is the id given to the most recently created cache.
+var lastCacheID uint64
+
+func nanotime() int64 {
+	return time.Now().UnixNano()
 }
 
 // Add an item to the cache, replacing any existing item. If the duration is 0
 // (DefaultExpiration), the cache's default expiration time is used. If it is -1
-// (NoExpiration), the item never expires.
+// (NoExpiration), the item never expires. The write is dropped if it fails
+// the cache's write validator, or if the key is new, the cache is full and
+// its overflow policy is RejectNew, or if the cache is closed; use
+// SetValidated to detect this.
 func (c *cache[K, V]) Set(k K, x V, d time.Duration) {
-	// "Inlining" of set
-	var e int64
-	if d == DefaultExpiration {
-		d = c.defaultExpiration
-	}
-	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return
 	}
+	e := c.expiration(d)
 	c.Lock()
-	if idx, ok := c.indices[k]; ok {
-		c.items[idx].value = x
-		c.items[idx].key = k
-		c.items[idx].Expiration = e
-	} else {
-		idx := len(c.items)
-		c.items = append(c.items, entry[K, V]{key: k, value: x, Expiration: e})
-		c.indices[k] = idx
-	}
+	c.setEncoded(k, x, enc, e)
 	// TODO: Calls to mu.Unlock are currently not deferred because defer
 	// adds ~200 ns (as of go1.)
-	c.Unlock()
+	c.unlock()
 }
 
 func (c *cache[K, V]) SetDefault(k K, v V) {
 	c.Set(k, v, DefaultExpiration)
 }
 
-func (c *cache[K, V]) set(k K, x V, d time.Duration) {
-	var e int64
+// SetUntil sets an item that expires at deadline rather than after a
+// duration, capped by WithMaxTTL. A deadline in the past sets an item that
+// is already expired. It returns the reason if the write was refused, as
+// SetValidated does.
+func (c *cache[K, V]) SetUntil(k K, v V, deadline time.Time) error {
+	enc, err := c.encodeValue(v)
+	if err != nil {
+		return err
+	}
+	e := c.expirationAt(deadline)
+	c.Lock()
+	err = c.setEncoded(k, v, enc, e)
+	c.unlock()
+	return err
+}
+
+// SetCtx sets an item that expires at ctx's deadline, tying its lifetime to a
+// request, or with the default expiration if ctx has no deadline. It returns
+// the reason if the write was refused, as SetValidated does.
+func (c *cache[K, V]) SetCtx(ctx context.Context, k K, v V) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		return c.SetUntil(k, v, deadline)
+	}
+	return c.SetValidated(k, v, DefaultExpiration)
+}
+
+// SetValidated sets an item like Set, but returns the reason if the write was
+// dropped: the error from the cache's write validator, or ErrCapacity.
+func (c *cache[K, V]) SetValidated(k K, x V, d time.Duration) error {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return err
+	}
+	e := c.expiration(d)
+	c.Lock()
+	err = c.setEncoded(k, x, enc, e)
+	c.unlock()
+	return err
+}
+
+// SetTx sets all items with duration d, or none of them. Each item is first
+// checked with validate, if it is not nil, and with the cache's write
+// validator; if any check fails, nothing is set and the error for one of the
+// failing items is returned. In a cache bounded by WithMaxItems, room for all
+// the items is made before any is set, so that they cannot evict each other,
+// and without evicting the existing items they replace; if there are more
+// items than the bound, or the cache is full and its overflow policy is
+// RejectNew, nothing is set and ErrCapacity is returned.
+// Otherwise all items are set under one write lock, so readers see either
+// none or all of them.
+func (c *cache[K, V]) SetTx(items map[K]V, d time.Duration, validate func(K, V) error) error {
+	if validate != nil {
+		for k, x := range items {
+			if err := validate(k, x); err != nil {
+				return err
+			}
+		}
+	}
+	var encoded map[K]V
+	if c.encode != nil {
+		encoded = make(map[K]V, len(items))
+		for k, x := range items {
+			enc, err := c.encode(x)
+			if err != nil {
+				return err
+			}
+			encoded[k] = enc
+		}
+	}
+	e := c.expiration(d)
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return ErrClosed
+	}
+	if c.validate != nil {
+		for k, x := range items {
+			if err := c.validate(k, x); err != nil {
+				c.Unlock()
+				return err
+			}
+		}
+	}
+	if c.maxItems > 0 {
+		fits := func() bool {
+			n := len(c.items)
+			for k := range items {
+				if _, found := c.index(k); !found {
+					n++
+				}
+			}
+			return n <= c.maxItems
+		}
+		// evict only reclaims expired items under RejectNew, and one item
+		// at a time otherwise, never one of the batch's own keys: those
+		// would only have to be added back.
+		for !fits() {
+			if len(items) > c.maxItems || !c.evict(c.now(), items) {
+				c.unlock()
+				return ErrCapacity
+			}
+		}
+	}
+	for k, x := range items {
+		c.setEncoded(k, x, encoded[k], e)
+	}
+	c.unlock()
+	return nil
+}
+
+func (c *cache[K, V]) set(k K, x V, d time.Duration) error {
+	return c.setExpiration(k, x, c.expiration(d))
+}
+
+// expiration returns the absolute expiration time in nanoseconds for an item
+// set now with duration d, or 0 if the item never expires. DefaultExpiration
+// always resolves to the configured default, which is NoExpiration for caches
+// created without a positive default; any other d <= 0 means NoExpiration.
+func (c *cache[K, V]) expiration(d time.Duration) int64 {
 	if d == DefaultExpiration {
 		d = c.defaultExpiration
 	}
+	if c.maxTTL > 0 && (d <= 0 || d > c.maxTTL) {
+		d = c.maxTTL
+	}
 	if d > 0 {
-		e = time.Now().Add(d).UnixNano()
+		return c.now() + int64(d)
+	}
+	return 0
+}
+
+// expirationAt resolves an absolute deadline to an expiration time, applying
+// WithMaxTTL like expiration does for durations.
+func (c *cache[K, V]) expirationAt(deadline time.Time) int64 {
+	now := c.now()
+	d := time.Duration(deadline.UnixNano() - now)
+	if c.maxTTL > 0 && d > c.maxTTL {
+		d = c.maxTTL
+	}
+	if d <= 0 {
+		// The deadline has passed. Keep the item expired, rather than
+		// returning 0, which would mean it never expires.
+		d = -1
+	}
+	return now + int64(d)
+}
+
+// capExpiration applies WithMaxTTL to an absolute expiration time e taken
+// from elsewhere, such as another cache, like expiration does for durations.
+func (c *cache[K, V]) capExpiration(e int64) int64 {
+	if c.maxTTL <= 0 {
+		return e
+	}
+	if max := c.now() + int64(c.maxTTL); e == 0 || e > max {
+		return max
+	}
+	return e
+}
+
+// load returns the caller-facing form of the stored value x of k. If x
+// cannot be decoded, it reports the error to the error handler and returns
+// false, and callers treat the item as missing.
+func (c *cache[K, V]) load(k K, x V) (V, bool) {
+	if c.decode != nil {
+		var err error
+		if x, err = c.decode(x); err != nil {
+			c.handleError(fmt.Errorf("simplecache: decoding the value of key %v: %w", k, err))
+			var zero V
+			return zero, false
+		}
+	}
+	if c.copyOnGet {
+		x = c.copyValue(x)
+	}
+	return x, true
+}
+
+// encodeValue returns x in the form setEncoded stores it in. Callers run it
+// before taking the write lock, so that a slow encoder such as a compression
+// codec does not hold up other goroutines.
+func (c *cache[K, V]) encodeValue(x V) (enc V, err error) {
+	if c.encode == nil {
+		return enc, nil
 	}
+	return c.encode(x)
+}
 
-	if idx, ok := c.indices[k]; ok {
+// setExpiration stores x under k with the absolute expiration e. It refuses
+// the write with the validator's error if it fails the write validator, and
+// with ErrCapacity if k is new, the cache is full and the overflow policy is
+// RejectNew. The caller must hold the write lock.
+func (c *cache[K, V]) setExpiration(k K, x V, e int64) error {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return err
+	}
+	return c.setEncoded(k, x, enc, e)
+}
+
+// setEncoded is setExpiration for a value x already passed through
+// encodeValue, giving enc. The caller must hold the write lock.
+func (c *cache[K, V]) setEncoded(k K, x, enc V, e int64) error {
+	if c.closed {
+		return ErrClosed
+	}
+	if c.validate != nil {
+		if err := c.validate(k, x); err != nil {
+			return err
+		}
+	}
+	now := c.now()
+	idx, found := c.index(k)
+	if !found && c.maxItems > 0 && len(c.items) >= c.maxItems && !c.evict(now, nil) {
+		return ErrCapacity
+	}
+	if c.copyValue != nil {
+		x = c.copyValue(x)
+	}
+	if c.keyTags != nil {
+		c.untag(k)
+	}
+	if c.watchers != nil {
+		c.notifyWatchers(k, x)
+	}
+	if c.encode != nil {
+		c.countCompressed(x, enc)
+		x = enc
+	}
+	if found {
 		c.items[idx].value = x
 		c.items[idx].key = k
 		c.items[idx].Expiration = e
+		c.items[idx].createdAt = now
+		c.items[idx].lastAccess = now
+		c.items[idx].hits = 0
+		c.items[idx].gen = c.gen
 	} else {
-		idx := len(c.items)
-		c.items = append(c.items, entry[K, V]{key: k, value: x, Expiration: e})
-		c.indices[k] = idx
+		c.items = append(c.items, entry[K, V]{key: k, value: x, Expiration: e, createdAt: now, lastAccess: now, gen: c.gen})
+		c.indexAppended(k)
+		if len(c.items) > c.peak {
+			c.peak = len(c.items)
+		}
+	}
+	if c.ttlHist != nil {
+		c.recordExpiration(e, now)
+	}
+	return nil
+}
+
+// evict makes room for a new item and reports whether it did. It looks at a
+// random sample of items, or at all of them if there are no more than the
+// sample size. Every expired or idle item in the sample is reclaimed, since
+// those would otherwise hold slots that live items need; only if there are
+// none is the oldest sampled item not in keep evicted, unless the overflow
+// policy is RejectNew. The caller must hold the write lock and release it
+// with unlock.
+func (c *cache[K, V]) evict(now int64, keep map[K]V) bool {
+	n := c.evictionSamples
+	if n <= 0 {
+		n = defaultEvictionSamples
+	}
+	if len(c.items) <= n {
+		return c.evictScan(now, keep)
+	}
+	reclaimed := false
+	victim := -1
+	for ; n > 0 && len(c.items) > 0; n-- {
+		i := rand.Intn(len(c.items))
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			// delete moves the last item into slot i, so victim may no
+			// longer be valid; it isn't needed once something is reclaimed.
+			c.evictKey(item.key)
+			reclaimed = true
+			continue
+		}
+		if _, found := keep[item.key]; found {
+			continue
+		}
+		if victim < 0 || item.createdAt < c.items[victim].createdAt {
+			victim = i
+		}
+	}
+	if reclaimed || len(c.items) == 0 {
+		return true
+	}
+	if c.overflow == RejectNew {
+		return false
+	}
+	if victim < 0 {
+		// Every sampled item is to be kept.
+		return c.evictScan(now, keep)
+	}
+	c.evictKey(c.items[victim].key)
+	return true
+}
+
+// evictScan is evict looking at every item.
+func (c *cache[K, V]) evictScan(now int64, keep map[K]V) bool {
+	reclaimed := false
+	// Walk backwards so that the item delete swaps into slot i has already
+	// been checked.
+	for i := len(c.items) - 1; i >= 0; i-- {
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			c.evictKey(item.key)
+			reclaimed = true
+		}
+	}
+	if reclaimed || len(c.items) == 0 {
+		return true
+	}
+	if c.overflow == RejectNew {
+		return false
+	}
+	victim := -1
+	for i := range c.items {
+		if _, found := keep[c.items[i].key]; found {
+			continue
+		}
+		if victim < 0 || c.items[i].createdAt < c.items[victim].createdAt {
+			victim = i
+		}
+	}
+	if victim < 0 {
+		return false
+	}
+	c.evictKey(c.items[victim].key)
+	return true
+}
+
+// evictKey deletes k and queues it for onEvicted, which unlock calls.
+func (c *cache[K, V]) evictKey(k K) {
+	c.logf("evicting %v to make room", k)
+	if v, evicted := c.delete(k); evicted {
+		c.evictedKeys = append(c.evictedKeys, k)
+		c.evictedValues = append(c.evictedValues, v)
+	}
+}
+
+// notifyEvicted calls the onEvicted callback f for an evicted item, or queues
+// the call with WithAsyncEvictions.
+func (c *cache[K, V]) notifyEvicted(f func(K, V), k K, v V) {
+	if c.evictQueue != nil && c.queueEvicted(f, k, v) {
+		return
+	}
+	c.callEvicted(f, k, v)
+}
+
+// callEvicted calls f for an evicted item. A panic in f is recovered and
+// reported to the error handler, so that a buggy callback cannot stop the
+// janitor or the callbacks for the remaining items.
+func (c *cache[K, V]) callEvicted(f func(K, V), k K, v V) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.handleError(fmt.Errorf("simplecache: onEvicted panicked for key %v: %v", k, r))
+		}
+	}()
+	if v, ok := c.load(k, v); ok {
+		f(k, v)
+	}
+}
+
+// handleError reports an error that cannot be returned to a caller.
+func (c *cache[K, V]) handleError(err error) {
+	if c.errorHandler != nil {
+		c.errorHandler(err)
+		return
+	}
+	log.Print(err)
+}
+
+// unlock releases the write lock and then calls onEvicted for the items that
+// were evicted to make room while it was held.
+func (c *cache[K, V]) unlock() {
+	if len(c.evictedKeys) == 0 {
+		c.Unlock()
+		return
+	}
+	ks, vs, f := c.evictedKeys, c.evictedValues, c.onEvicted
+	c.evictedKeys, c.evictedValues = nil, nil
+	c.Unlock()
+	for i := range ks {
+		c.notifyEvicted(f, ks[i], vs[i])
 	}
 }
 
 // Add an item to the cache, replacing any existing item, using the default
 // expiration.
 func (c *cache[K, V]) Add(k K, x V, d time.Duration) error {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return err
+	}
+	e := c.expiration(d)
 	c.Lock()
 	_, found := c.get(k)
 	if found {
 		c.Unlock()
 		return fmt.Errorf("Item %v alread exists ", k)
 	}
-	c.set(k, x, d)
+	err = c.setEncoded(k, x, enc, e)
+	c.unlock()
+	return err
+}
+
+// UpdateValue replaces the value of an unexpired item in place, keeping its
+// expiration, creation time and tags, and reports whether it did. It returns
+// false if the key is missing or expired, or if the write is refused by the
+// validator (see WithWriteValidator) or because the cache is closed. It looks
+// the key up once and touches nothing but the value, which makes it cheaper
+// than Set for frequent updates.
+func (c *cache[K, V]) UpdateValue(k K, x V) bool {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return false
+	}
+	c.Lock()
+	idx, found := c.index(k)
+	if c.closed || !found || c.items[idx].gen != c.gen {
+		c.Unlock()
+		return false
+	}
+	item := &c.items[idx]
+	if item.Expiration > 0 || c.idleExpiration > 0 {
+		now := c.now()
+		if (item.Expiration > 0 && now > item.Expiration) || c.idleExpired(item, now) {
+			c.Unlock()
+			return false
+		}
+	}
+	if c.validate != nil {
+		if err := c.validate(k, x); err != nil {
+			c.Unlock()
+			return false
+		}
+	}
+	if c.copyValue != nil {
+		x = c.copyValue(x)
+	}
+	if c.watchers != nil {
+		c.notifyWatchers(k, x)
+	}
+	if c.encode != nil {
+		c.countCompressed(x, enc)
+		x = enc
+	}
+	item.value = x
 	c.Unlock()
-	return nil
+	return true
 }
 
-// Checks if a key exists in cache
+// AddOrGet adds x to the cache like Add if there is no unexpired item for k,
+// returning x and true. Otherwise it returns the existing value and false,
+// without the error Add would allocate. If the write is refused as by Set,
+// it returns x and false. The check and the write happen under one write
+// lock.
+func (c *cache[K, V]) AddOrGet(k K, x V, d time.Duration) (actual V, added bool) {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return x, false
+	}
+	e := c.expiration(d)
+	c.Lock()
+	now := c.now()
+	if idx, found := c.lookup(k, now); found {
+		c.recordAccess(&c.items[idx], now)
+		v := c.items[idx].value
+		c.Unlock()
+		v, _ = c.load(k, v)
+		return v, false
+	}
+	err = c.setEncoded(k, x, enc, e)
+	c.unlock()
+	return x, err == nil
+}
+
+// Swap sets the item for k like Set and returns the value it replaced, with a
+// bool reporting whether there was an unexpired one. If the write is refused,
+// as by SetValidated, the item is left alone and the reason is returned with
+// a zero value and false. The read and the write happen under one write lock.
+func (c *cache[K, V]) Swap(k K, x V, d time.Duration) (old V, had bool, err error) {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return old, false, err
+	}
+	e := c.expiration(d)
+	c.Lock()
+	old, had = c.get(k)
+	if err = c.setEncoded(k, x, enc, e); err != nil {
+		var zero V
+		old, had = zero, false
+	}
+	c.unlock()
+	if had {
+		old, had = c.load(k, old)
+	}
+	return old, had, err
+}
+
+// ReplaceIfStale sets the item for k like Set, but only if the current item
+// expires in less than staleBelow, or is missing or expired. Items that never
+// expire are never replaced. It reports whether the item was set, which it
+// may also not be if the write is refused as by Set. The check
+// and the write happen under one write lock.
+func (c *cache[K, V]) ReplaceIfStale(k K, x V, d time.Duration, staleBelow time.Duration) bool {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return false
+	}
+	e := c.expiration(d)
+	c.Lock()
+	now := c.now()
+	if idx, found := c.lookup(k, now); found {
+		if exp := c.items[idx].Expiration; exp == 0 || exp-now >= int64(staleBelow) {
+			c.Unlock()
+			return false
+		}
+	}
+	err = c.setEncoded(k, x, enc, e)
+	c.unlock()
+	return err == nil
+}
+
+// Checks if an unexpired item exists in the cache for the key
 func (c *cache[K, V]) Contains(k K) bool {
 	c.RLock()
-	_, found := c.indices[k]
+	idx, found := c.index(k)
+	found = found && c.items[idx].gen == c.gen
+	if found && (c.items[idx].Expiration > 0 || c.idleExpiration > 0) {
+		found = c.alive(&c.items[idx], c.now())
+	}
 	c.RUnlock()
 	return found
 }
 
-// Get an item from the cache. Returns the item or nil, and a bool indicating
-// whether the key was found.
+// get returns the stored form of the unexpired item for k, which callers pass
+// through load, preferably once they have released the lock. The caller must
+// hold the lock.
 func (c *cache[K, V]) get(k K) (v V, ok bool) {
 	// "Inlining" of get and Expired
-	idx, found := c.indices[k]
-	if !found {
+	idx, found := c.index(k)
+	if !found || c.items[idx].gen != c.gen {
 		return v, false
 	}
 
 	item := &c.items[idx]
-	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
+	if (item.Expiration > 0 || c.idleExpiration > 0) && !c.alive(item, c.now()) {
+		return v, false
+	}
+	return item.value, true
+}
+
+// idleExpired reports whether item has gone unread for longer than the idle
+// expiration set with WithIdleExpiration.
+func (c *cache[K, V]) idleExpired(item *entry[K, V], now int64) bool {
+	return c.idleExpiration > 0 && now-atomic.LoadInt64(&item.lastAccess) > int64(c.idleExpiration)
+}
+
+// alive reports whether item is unexpired, not idle and not invalidated at
+// now.
+func (c *cache[K, V]) alive(item *entry[K, V], now int64) bool {
+	return item.gen == c.gen && (item.Expiration == 0 || now <= item.Expiration) && !c.idleExpired(item, now)
+}
+
+// recordAccess records a read of item at now, for idle expiration, IdleTime
+// and GetWithHits, and returns the item's hit count. Every method that
+// returns an item to the caller as a hit calls it. Only the read lock may be
+// held, so the access time and hit count are updated atomically.
+func (c *cache[K, V]) recordAccess(item *entry[K, V], now int64) uint64 {
+	if c.trackAccess {
+		atomic.StoreInt64(&item.lastAccess, now)
+	}
+	return atomic.AddUint64(&item.hits, 1)
+}
+
+// lookup returns the position of the item for k if it is alive at now. The
+// caller must hold the lock.
+func (c *cache[K, V]) lookup(k K, now int64) (int, bool) {
+	idx, found := c.index(k)
+	if !found || !c.alive(&c.items[idx], now) {
+		return 0, false
+	}
+	return idx, true
+}
+
+// Get an item from the cache. Returns the item or nil, and a bool indicating
+// whether the key was found.
+func (c *cache[K, V]) Get(k K) (v V, ok bool) {
+	c.RLock()
+	idx, found := c.index(k)
+	if !found || c.items[idx].gen != c.gen {
+		c.miss(k, found)
+		return v, false
+	}
+
+	item := &c.items[idx]
+	var now int64
+	if item.Expiration > 0 || c.trackAccess {
+		now = c.now()
+	}
+	if c.idleExpired(item, now) {
+		c.miss(k, true)
+		return v, false
+	}
+	if item.Expiration > 0 && now > item.Expiration {
+		if c.staleFor <= 0 || now > item.Expiration+int64(c.staleFor) {
+			c.miss(k, true)
 			return v, false
 		}
+		// Serve the stale value and revalidate in the background
+		c.recordAccess(item, now)
+		v = item.value
+		c.RUnlock()
+		c.refresh(k)
+		return c.load(k, v)
+	}
+	c.recordAccess(item, now)
+	v = item.value
+	c.RUnlock()
+	v, ok = c.load(k, v)
+	return v, ok
+}
+
+// miss releases the read lock held by Get after a miss on k and calls the
+// onMiss callback. If present is true, k is in the cache but expired, idle or
+// invalidated, and with WithEvictOnGet it is deleted.
+func (c *cache[K, V]) miss(k K, present bool) {
+	onMiss, evict := c.onMiss, present && c.evictOnGet
+	c.RUnlock()
+	if evict {
+		c.deleteIfDead(k)
+	}
+	if onMiss != nil {
+		onMiss(k)
+	}
+}
+
+// deleteIfDead deletes k if it has expired beyond any stale period, gone idle
+// or been invalidated, and calls onEvicted for it. The write lock is taken
+// afresh, so k is checked again in case it was set in the meantime.
+func (c *cache[K, V]) deleteIfDead(k K) {
+	c.Lock()
+	idx, found := c.index(k)
+	if !found {
+		c.Unlock()
+		return
+	}
+	item := &c.items[idx]
+	now := c.now()
+	if !(item.Expiration > 0 && now > item.Expiration+int64(c.staleFor)) && !c.idleExpired(item, now) && item.gen == c.gen {
+		c.Unlock()
+		return
+	}
+	v, evicted := c.delete(k)
+	c.Unlock()
+	if evicted {
+		c.notifyEvicted(c.onEvicted, k, v)
+	}
+}
+
+// refresh reloads k in the background unless a refresh of k is already in
+// flight.
+func (c *cache[K, V]) refresh(k K) {
+	c.refreshMu.Lock()
+	if _, ok := c.refreshing[k]; ok {
+		c.refreshMu.Unlock()
+		return
+	}
+	c.refreshing[k] = struct{}{}
+	c.refreshMu.Unlock()
+
+	go func() {
+		if v, err := c.loadStale(k); err == nil {
+			c.Set(k, v, DefaultExpiration)
+		} else {
+			c.logf("refreshing %v: %v", k, err)
+		}
+		c.refreshMu.Lock()
+		delete(c.refreshing, k)
+		c.refreshMu.Unlock()
+	}()
+}
+
+// loadStale calls the serve-stale loader for k, turning a panic in it into an
+// error so that it cannot crash the program from the refresh goroutine.
+func (c *cache[K, V]) loadStale(k K) (v V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("simplecache: loader panicked for key %v: %v", k, r)
+			c.handleError(err)
+		}
+	}()
+	return c.loader(k)
+}
+
+// GetBatch looks up all keys under a single read lock. The returned slices
+// are parallel to keys: values[i] is the item for keys[i] and found[i]
+// reports whether it was found. Duplicate keys are looked up once per
+// occurrence.
+func (c *cache[K, V]) GetBatch(keys []K) (values []V, found []bool) {
+	values = make([]V, len(keys))
+	found = make([]bool, len(keys))
+	c.RLock()
+	now := c.now()
+	for i, k := range keys {
+		if idx, ok := c.lookup(k, now); ok {
+			c.recordAccess(&c.items[idx], now)
+			values[i], found[i] = c.items[idx].value, true
+		}
+	}
+	c.RUnlock()
+	for i, k := range keys {
+		if found[i] {
+			values[i], found[i] = c.load(k, values[i])
+		}
+	}
+	return values, found
+}
+
+// GetOrDefault returns the item for k, or def if it is not in the cache or
+// has expired. def is not stored in the cache.
+func (c *cache[K, V]) GetOrDefault(k K, def V) V {
+	if v, ok := c.Get(k); ok {
+		return v
+	}
+	return def
+}
+
+// GetErr returns the item for k, or an error wrapping ErrNotFound if it is not
+// in the cache or has expired.
+func (c *cache[K, V]) GetErr(k K) (V, error) {
+	v, ok := c.Get(k)
+	if !ok {
+		return v, fmt.Errorf("%w: %v", ErrNotFound, k)
+	}
+	return v, nil
+}
+
+// GetResetTTL gets an item like Get and, on a hit, resets its expiration to
+// now plus the cache's default expiration, so that any access keeps the item
+// alive. The lookup and the reset happen under one write lock.
+func (c *cache[K, V]) GetResetTTL(k K) (v V, ok bool) {
+	e := c.expiration(DefaultExpiration)
+	c.Lock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
+	if !found {
+		c.Unlock()
+		return v, false
+	}
+	c.recordAccess(&c.items[idx], now)
+	if !c.closed {
+		c.items[idx].Expiration = e
+	}
+	v = c.items[idx].value
+	c.Unlock()
+	v, ok = c.load(k, v)
+	return v, ok
+}
+
+// GetEvenIfExpired returns an item whether or not it has expired, as long as
+// it has not been deleted yet, together with whether it has expired or gone
+// idle. ok is false if the key is not in the cache at all. Together with
+// Revive it gives expired items a grace period until the janitor, or
+// DeleteExpired, reclaims them.
+func (c *cache[K, V]) GetEvenIfExpired(k K) (v V, expired bool, ok bool) {
+	c.RLock()
+	idx, found := c.index(k)
+	if !found || c.items[idx].gen != c.gen {
+		c.RUnlock()
+		return v, false, false
+	}
+	item := &c.items[idx]
+	now := c.now()
+	expired = (item.Expiration > 0 && now > item.Expiration) || c.idleExpired(item, now)
+	v = item.value
+	c.RUnlock()
+	v, ok = c.load(k, v)
+	return v, expired, ok
+}
+
+// Revive gives an item a new lifetime of d, interpreted as in Set, even if it
+// has expired, as long as it has not been deleted yet. It also counts as an
+// access for idle expiration. It reports whether the item was found.
+func (c *cache[K, V]) Revive(k K, d time.Duration) bool {
+	e := c.expiration(d)
+	c.Lock()
+	idx, found := c.index(k)
+	if c.closed || !found || c.items[idx].gen != c.gen {
+		c.Unlock()
+		return false
+	}
+	c.items[idx].Expiration = e
+	c.items[idx].lastAccess = c.now()
+	c.Unlock()
+	return true
+}
+
+// GetAndTouch gets an item like Get and, on a hit, sets its expiration to
+// extendTo from now, interpreted like the duration passed to Set, as
+// TouchMany does. renewed reports whether the expiration was set, which is
+// the case on every hit. The lookup and the renewal happen under one write
+// lock.
+func (c *cache[K, V]) GetAndTouch(k K, extendTo time.Duration) (v V, renewed bool, ok bool) {
+	e := c.expiration(extendTo)
+	c.Lock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
+	if !found {
+		c.Unlock()
+		return v, false, false
+	}
+	item := &c.items[idx]
+	c.recordAccess(item, now)
+	if c.closed {
+		v = item.value
+		c.Unlock()
+		v, ok = c.load(k, v)
+		return v, false, ok
 	}
-	return item.value, true
+	item.Expiration = e
+	v = item.value
+	c.Unlock()
+	v, ok = c.load(k, v)
+	return v, true, ok
 }
 
-// Get an item from the cache. Returns the item or nil, and a bool indicating
-// whether the key was found.
-func (c *cache[K, V]) Get(k K) (v V, ok bool) {
-	c.RLock()
-	idx, found := c.indices[k]
+// GetOrExtend gets an item like Get and, if it expires in less than
+// minRemaining, sets its expiration to extendTo from now, interpreted like
+// the duration passed to Set. It reports whether the expiration was extended
+// and whether the key was found. Items that never expire are not extended.
+// The lookup and the extension happen under one write lock.
+func (c *cache[K, V]) GetOrExtend(k K, minRemaining, extendTo time.Duration) (v V, extended bool, ok bool) {
+	e := c.expiration(extendTo)
+	c.Lock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
 	if !found {
-		c.RUnlock()
-		return v, false
+		c.Unlock()
+		return v, false, false
 	}
-
-	if c.items[idx].Expiration > 0 {
-		if time.Now().UnixNano() > c.items[idx].Expiration {
-			c.RUnlock()
-			return v, false
-		}
+	item := &c.items[idx]
+	c.recordAccess(item, now)
+	if !c.closed && item.Expiration > 0 && item.Expiration-now < int64(minRemaining) {
+		item.Expiration = e
+		extended = true
 	}
-	v = c.items[idx].value
-	c.RUnlock()
-	return v, true
+	v = item.value
+	c.Unlock()
+	v, ok = c.load(k, v)
+	return v, extended, ok
 }
 
 // Get renewal when lt defaltExpiration/2
 func (c *cache[K, V]) GetAndRenewal(k K) (v V, ok bool) {
-	c.RLock()
-	idx, found := c.indices[k]
+	c.Lock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
 	if !found {
-		c.RUnlock()
+		c.Unlock()
 		return v, false
 	}
 
-	c.items[idx].Lock()
-	now := time.Now().UnixNano()
+	c.recordAccess(&c.items[idx], now)
 	exp := int64(c.defaultExpiration / 3)
-	if c.items[idx].Expiration > 0 && c.items[idx].Expiration-now <= exp {
+	if !c.closed && c.items[idx].Expiration > 0 && c.items[idx].Expiration-now <= exp {
 		c.items[idx].Expiration += exp
 	}
-	c.items[idx].Unlock()
 	v = c.items[idx].value
 
-	c.RUnlock()
-	return v, true
+	c.Unlock()
+	return c.load(k, v)
 }
 
-// GetPointer
+// GetPointer returns a pointer to the stored value. With WithCompression the
+// stored value is the compressed one.
 func (c *cache[K, V]) GetPointer(k K) (v *V, ok bool) {
 	c.RLock()
-	idx, found := c.indices[k]
-	if !found {
+	idx, found := c.index(k)
+	if !found || c.items[idx].gen != c.gen {
+		onMiss := c.onMiss
 		c.RUnlock()
+		if onMiss != nil {
+			onMiss(k)
+		}
 		return nil, false
 	}
 
-	if c.items[idx].Expiration > 0 {
-		if time.Now().UnixNano() > c.items[idx].Expiration {
-			c.RUnlock()
-			return v, false
+	var now int64
+	if c.items[idx].Expiration > 0 || c.trackAccess {
+		now = c.now()
+	}
+	if (c.items[idx].Expiration > 0 || c.idleExpiration > 0) && !c.alive(&c.items[idx], now) {
+		onMiss := c.onMiss
+		c.RUnlock()
+		if onMiss != nil {
+			onMiss(k)
 		}
+		return v, false
 	}
+	c.recordAccess(&c.items[idx], now)
 	v = &c.items[idx].value
 	c.RUnlock()
 	return v, true
@@ -205,28 +1270,167 @@ func (c *cache[K, V]) GetPointer(k K) (v *V, ok bool) {
 
 func (c *cache[K, V]) GetWithExpiration(k K) (v V, t time.Time, ok bool) {
 	c.RLock()
-	idx, found := c.indices[k]
+	now := c.now()
+	idx, found := c.lookup(k, now)
 	if !found {
+		onMiss := c.onMiss
 		c.RUnlock()
+		if onMiss != nil {
+			onMiss(k)
+		}
 		return v, t, false
 	}
-
 	item := &c.items[idx]
+	c.recordAccess(item, now)
 	if item.Expiration > 0 {
-		if time.Now().UnixNano() > item.Expiration {
-			c.RUnlock()
-			return v, t, false
+		t = time.Unix(0, item.Expiration)
+	}
+	v = item.value
+	c.RUnlock()
+	v, ok = c.load(k, v)
+	return v, t, ok
+}
+
+// TouchMany resets the expiration of every present, unexpired key in keys to
+// now+d under a single write lock, and returns the number of keys touched.
+// Missing and expired keys are skipped. d is interpreted like the duration
+// passed to Set.
+func (c *cache[K, V]) TouchMany(keys []K, d time.Duration) int {
+	n := 0
+	e := c.expiration(d)
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return 0
+	}
+	now := c.now()
+	for _, k := range keys {
+		if idx, found := c.lookup(k, now); found {
+			c.items[idx].Expiration = e
+			n++
 		}
+	}
+	c.Unlock()
+	return n
+}
+
+// GetWithTTL returns an item together with the time it has left before it
+// expires, or NoExpiration if it never expires, and a bool indicating
+// whether the key was found.
+func (c *cache[K, V]) GetWithTTL(k K) (v V, ttl time.Duration, ok bool) {
+	c.RLock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
+	if !found {
+		c.RUnlock()
+		return v, 0, false
+	}
+	item := &c.items[idx]
+	c.recordAccess(item, now)
+	ttl = NoExpiration
+	if item.Expiration > 0 {
+		ttl = time.Duration(item.Expiration - now)
+	}
+	v = item.value
+	c.RUnlock()
+	v, ok = c.load(k, v)
+	return v, ttl, ok
+}
+
+// GetWithHits returns an item together with the number of times it has been
+// read since it was set, this read included, and a bool indicating
+// whether the key was found. Unlike a recency order, the count tells keys
+// that are read steadily from keys that were merely read last.
+func (c *cache[K, V]) GetWithHits(k K) (v V, hits uint64, ok bool) {
+	c.RLock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
+	if !found {
+		c.RUnlock()
+		return v, 0, false
+	}
+	item := &c.items[idx]
+	hits = c.recordAccess(item, now)
+	v = item.value
+	c.RUnlock()
+	v, ok = c.load(k, v)
+	return v, hits, ok
+}
 
-		// Return the item and the expiration time
+// GetWithTimes returns an item together with the time it was set and its
+// expiration time (a zero time.Time if it never expires), and a bool
+// indicating whether the key was found.
+func (c *cache[K, V]) GetWithTimes(k K) (v V, created, expiration time.Time, ok bool) {
+	c.RLock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
+	if !found {
+		c.RUnlock()
+		return v, created, expiration, false
+	}
+	item := &c.items[idx]
+	c.recordAccess(item, now)
+	if item.Expiration > 0 {
+		expiration = time.Unix(0, item.Expiration)
+	}
+	v, created = item.value, time.Unix(0, item.createdAt)
+	c.RUnlock()
+	v, ok = c.load(k, v)
+	return v, created, expiration, ok
+}
+
+// GetWithCreated returns an item together with the time it was set, and a
+// bool indicating whether the key was found. See GetWithTimes.
+func (c *cache[K, V]) GetWithCreated(k K) (v V, created time.Time, ok bool) {
+	v, created, _, ok = c.GetWithTimes(k)
+	return v, created, ok
+}
+
+// IdleTime returns how long it has been since the item for k was last read
+// with Get, or since it was set if it has not been read since. It returns
+// false if the key is not found or has expired, and always if the cache was
+// created without WithAccessTracking (or WithIdleExpiration), since reads are
+// not tracked then.
+func (c *cache[K, V]) IdleTime(k K) (time.Duration, bool) {
+	if !c.trackAccess {
+		return 0, false
+	}
+	c.RLock()
+	now := c.now()
+	idx, found := c.lookup(k, now)
+	if !found {
 		c.RUnlock()
-		return item.value, time.Unix(0, item.Expiration), true
+		return 0, false
 	}
+	d := time.Duration(now - atomic.LoadInt64(&c.items[idx].lastAccess))
+	c.RUnlock()
+	return d, true
+}
 
-	// If expiration <= 0 (i.e. no expiration time set) then return the item
-	// and a zeroed time.Time
+// AgeBounds returns the earliest and latest times at which the unexpired items
+// in the cache were set. ok is false if the cache holds no unexpired items.
+func (c *cache[K, V]) AgeBounds() (oldest, newest time.Time, ok bool) {
+	var lo, hi int64
+	c.RLock()
+	now := c.now()
+	for i := range c.items {
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			continue
+		}
+		if !ok || item.createdAt < lo {
+			lo = item.createdAt
+		}
+		if !ok || item.createdAt > hi {
+			hi = item.createdAt
+		}
+		ok = true
+	}
 	c.RUnlock()
-	return item.value, t, true
+	if !ok {
+		return oldest, newest, false
+	}
+	return time.Unix(0, lo), time.Unix(0, hi), true
 }
 
 // Delete an item from the cache. Does nothing if the key is not in the cache.
@@ -235,42 +1439,143 @@ func (c *cache[K, V]) Delete(k K) {
 	v, evicted := c.delete(k)
 	c.Unlock()
 	if evicted {
-		c.onEvicted(k, v)
+		c.notifyEvicted(c.onEvicted, k, v)
 	}
 }
 
+// Remove is an alias for Delete.
+//
+// Deprecated: Delete is the canonical name; use it instead.
+func (c *cache[K, V]) Remove(k K) {
+	c.Delete(k)
+}
+
 func (c *cache[K, V]) delete(k K) (v V, ok bool) {
-	idx, found := c.indices[k]
+	idx, found := c.index(k)
 	if !found {
 		return
 	}
 
-	// target value
-	// copy
+	// target value, in its stored form; callEvicted decodes it
 	v = c.items[idx].value
 
-	n := len(c.indices) - 1
+	n := len(c.items) - 1
 	c.items[n], c.items[idx] = c.items[idx], c.items[n]
-	c.indices[c.items[idx].key] = idx
-	delete(c.indices, k)
+	if c.indices != nil {
+		c.indices[c.items[idx].key] = idx
+		delete(c.indices, k)
+	}
 	c.items = c.items[:n]
+	if c.scanMax > 0 && n <= c.scanMax/2 {
+		c.indices = nil
+	}
+	if c.keyTags != nil {
+		c.untag(k)
+	}
+	if c.watchers != nil {
+		c.closeWatchers(k)
+	}
 	return v, c.onEvicted != nil
 }
 
 // Delete all expired items from the cache.
 func (c *cache[K, V]) DeleteExpired() {
+	c.deleteExpired()
+}
+
+// deleteExpired deletes all expired items and returns how many it deleted
+// and how many items the cache held before.
+func (c *cache[K, V]) deleteExpired() (deleted, total int) {
 	var ks []K
 	var vs []V
-	now := time.Now().UnixNano()
+	if c.cleanupBatch > 0 {
+		ks, vs, total = c.deleteExpiredInBatches()
+	} else {
+		now := c.now()
+		stale := now - int64(c.staleFor)
+		c.Lock()
+		total = len(c.items)
+		// Search expired data, keeping items that may still be served stale
+		for i := range c.items {
+			v := &c.items[i]
+			if (v.Expiration > 0 && stale > v.Expiration) || c.idleExpired(v, now) || v.gen != c.gen {
+				ks = append(ks, v.key)
+			}
+		}
+
+		// delete
+		for _, k := range ks {
+			if v, evicted := c.delete(k); evicted {
+				vs = append(vs, v)
+			}
+		}
+		c.Unlock()
+	}
+	c.logf("cleanup deleted %d of %d items", len(ks), total)
+	for i := range vs {
+		c.notifyEvicted(c.onEvicted, ks[i], vs[i])
+	}
+	return len(ks), total
+}
+
+// deleteExpiredInBatches deletes expired items like deleteExpired, but
+// releases the write lock after every cleanupBatch items, see
+// WithCleanupBatch. It returns the deleted keys, the values to pass to
+// onEvicted and the number of items the cache held before.
+func (c *cache[K, V]) deleteExpiredInBatches() (ks []K, vs []V, total int) {
+	now := c.now()
 	c.Lock()
-	// Search expired data
-	for _, v := range c.items {
-		if v.Expiration > 0 && now > v.Expiration {
-			ks = append(ks, v.key)
+	total = len(c.items)
+	// Walk backwards: delete moves the last item into the freed slot, and
+	// that item has already been examined.
+	for i, n := len(c.items)-1, 0; i >= 0; i-- {
+		if n == c.cleanupBatch {
+			c.Unlock()
+			now = c.now()
+			c.Lock()
+			n = 0
+			// Items may have been deleted while the lock was released
+			if i >= len(c.items) {
+				i = len(c.items) - 1
+				if i < 0 {
+					break
+				}
+			}
+		}
+		n++
+		v := &c.items[i]
+		if (v.Expiration > 0 && now-int64(c.staleFor) > v.Expiration) || c.idleExpired(v, now) || v.gen != c.gen {
+			k := v.key
+			ks = append(ks, k)
+			if x, evicted := c.delete(k); evicted {
+				vs = append(vs, x)
+			}
 		}
 	}
+	c.Unlock()
+	return ks, vs, total
+}
+
+// Tidy is an alias for DeleteExpired.
+//
+// Deprecated: DeleteExpired is the canonical name; use it instead.
+func (c *cache[K, V]) Tidy() {
+	c.DeleteExpired()
+}
 
-	// delete
+// ExpireBefore deletes every item whose expiration time is before t, whatever
+// the current time, and returns the number of items deleted. Items that never
+// expire are kept. The onEvicted callback is called for the deleted items.
+func (c *cache[K, V]) ExpireBefore(t time.Time) int {
+	var ks []K
+	var vs []V
+	before := t.UnixNano()
+	c.Lock()
+	for i := range c.items {
+		if e := c.items[i].Expiration; e > 0 && e < before {
+			ks = append(ks, c.items[i].key)
+		}
+	}
 	for _, k := range ks {
 		if v, evicted := c.delete(k); evicted {
 			vs = append(vs, v)
@@ -278,8 +1583,117 @@ func (c *cache[K, V]) DeleteExpired() {
 	}
 	c.Unlock()
 	for i := range vs {
-		c.onEvicted(ks[i], vs[i])
+		c.notifyEvicted(c.onEvicted, ks[i], vs[i])
+	}
+	return len(ks)
+}
+
+// ItemWithExpiration is an item removed from the cache together with the
+// expiration time it had.
+type ItemWithExpiration[V any] struct {
+	Object     V
+	Expiration time.Time
+}
+
+// DrainExpired deletes all expired items from the cache and returns them in a
+// single locked pass. Unlike DeleteExpired, the onEvicted callback is not
+// called for the returned items.
+func (c *cache[K, V]) DrainExpired() []ItemWithExpiration[V] {
+	var ks []K
+	var items []ItemWithExpiration[V]
+	now := c.now() - int64(c.staleFor)
+	c.Lock()
+	for i := range c.items {
+		if c.items[i].Expiration > 0 && now > c.items[i].Expiration {
+			ks = append(ks, c.items[i].key)
+			items = append(items, ItemWithExpiration[V]{
+				Object:     c.items[i].value,
+				Expiration: time.Unix(0, c.items[i].Expiration),
+			})
+		}
+	}
+	for _, k := range ks {
+		c.delete(k)
+	}
+	c.Unlock()
+	n := 0
+	for i := range items {
+		if v, ok := c.load(ks[i], items[i].Object); ok {
+			items[i].Object = v
+			items[n] = items[i]
+			n++
+		}
+	}
+	return items[:n]
+}
+
+// AcquireLock uses the cache as an in-process lock table: it sets k to v only
+// if k is not already present (or has expired) and reports whether the lock
+// was acquired. ttl acts as a lease; once it elapses the lock can be taken by
+// another holder. ttl is interpreted like the duration passed to Set. It is
+// unrelated to Lock and TryLock, which lock the cache itself.
+func (c *cache[K, V]) AcquireLock(k K, v V, ttl time.Duration) bool {
+	enc, err := c.encodeValue(v)
+	if err != nil {
+		return false
+	}
+	e := c.expiration(ttl)
+	c.Lock()
+	if _, found := c.get(k); found {
+		c.Unlock()
+		return false
+	}
+	err = c.setEncoded(k, v, enc, e)
+	c.unlock()
+	return err == nil
+}
+
+// ReleaseLock releases a lock taken with AcquireLock. The key is deleted only
+// if it is still held with value v, so a holder whose lease expired cannot
+// release a lock that has since been taken by someone else. It reports whether
+// the lock was released. Values are compared as set by WithEquality, with
+// reflect.DeepEqual by default.
+func (c *cache[K, V]) ReleaseLock(k K, v V) bool {
+	return c.CompareAndDelete(k, v)
+}
+
+// Merge copies all unexpired items from other into the cache, keeping their
+// remaining lifetimes, capped by the cache's WithMaxTTL. If overwrite is
+// false, keys that already exist in the cache are left untouched. It returns
+// how many items were written, which leaves out items the cache refused as by
+// Set. Both caches are locked for the duration of the merge, in a consistent
+// order so that concurrent merges in opposite directions cannot deadlock.
+func (c *cache[K, V]) Merge(other *Cache[K, V], overwrite bool) (n int) {
+	o := other.cache
+	if o == c {
+		return 0
+	}
+	if c.id < o.id {
+		c.Lock()
+		o.RLock()
+	} else {
+		o.RLock()
+		c.Lock()
+	}
+	now := o.now()
+	for i := range o.items {
+		item := &o.items[i]
+		if !o.alive(item, now) {
+			continue
+		}
+		if !overwrite {
+			if _, found := c.get(item.key); found {
+				continue
+			}
+		}
+		v, ok := o.load(item.key, item.value)
+		if ok && c.setExpiration(item.key, v, c.capExpiration(item.Expiration)) == nil {
+			n++
+		}
 	}
+	o.RUnlock()
+	c.unlock()
+	return n
 }
 
 // Sets an (optional) function that is called with the key and value when an
@@ -291,24 +1705,118 @@ func (c *cache[K, V]) OnEvicted(f func(K, V)) {
 	c.Unlock()
 }
 
-// Copies all unexpired items in the cache into a new map and returns it.
+// Sets an (optional) function that is called with the key whenever Get,
+// GetPointer or GetWithExpiration does not find an item, including when the
+// item has expired. It is called without holding the cache lock. Set to nil
+// to disable.
+func (c *cache[K, V]) OnMiss(f func(K)) {
+	c.Lock()
+	c.onMiss = f
+	c.Unlock()
+}
+
+// Returns the keys of all unexpired items in the cache. The keys are in no
+// particular order, and the order changes as items are deleted; use
+// SortedKeys for a deterministic order.
 func (c *cache[K, V]) Keys() []K {
 	var ks []K
 	c.RLock()
 	defer c.RUnlock()
-	now := time.Now().UnixNano()
-	for _, v := range c.items {
-		// "Inlining" of Expired
-		if v.Expiration > 0 {
-			if now > v.Expiration {
-				continue
-			}
+	now := c.now()
+	for i := range c.items {
+		if !c.alive(&c.items[i], now) {
+			continue
+		}
+		ks = append(ks, c.items[i].key)
+	}
+	return ks
+}
+
+// KeysPage returns up to limit keys of unexpired items, skipping the first
+// offset, together with the total number of unexpired items. Pages follow the
+// same order as Keys, so they only line up with each other while no items are
+// deleted; page through SortedKeys instead if the cache is being modified.
+func (c *cache[K, V]) KeysPage(offset, limit int) (keys []K, total int) {
+	c.RLock()
+	now := c.now()
+	for i := range c.items {
+		if !c.alive(&c.items[i], now) {
+			continue
+		}
+		if total >= offset && len(keys) < limit {
+			keys = append(keys, c.items[i].key)
 		}
-		ks = append(ks, v.key)
+		total++
 	}
+	c.RUnlock()
+	return keys, total
+}
+
+// SortedKeys returns the keys of all unexpired items in the cache, sorted by
+// less.
+func (c *cache[K, V]) SortedKeys(less func(a, b K) bool) []K {
+	ks := c.Keys()
+	sort.Slice(ks, func(i, j int) bool {
+		return less(ks[i], ks[j])
+	})
 	return ks
 }
 
+// Name returns the name given to the cache with WithName, or "" if it has
+// none.
+func (c *cache[K, V]) Name() string {
+	return c.name
+}
+
+// snapshot copies all unexpired items into a new map under a read lock.
+func (c *cache[K, V]) snapshot() map[K]V {
+	c.RLock()
+	m := make(map[K]V, len(c.items))
+	c.copyItems(m)
+	c.RUnlock()
+	c.loadItems(m)
+	return m
+}
+
+// CopyTo clears dst and fills it with all unexpired items in the cache. Reusing
+// the same map across calls saves allocating a new one for every snapshot.
+func (c *cache[K, V]) CopyTo(dst map[K]V) {
+	for k := range dst {
+		delete(dst, k)
+	}
+	c.RLock()
+	c.copyItems(dst)
+	c.RUnlock()
+	c.loadItems(dst)
+}
+
+// copyItems adds all unexpired items to m in their stored form, to be passed
+// to loadItems once the lock is released. The caller must hold the read lock.
+func (c *cache[K, V]) copyItems(m map[K]V) {
+	now := c.now()
+	for i := range c.items {
+		if !c.alive(&c.items[i], now) {
+			continue
+		}
+		m[c.items[i].key] = c.items[i].value
+	}
+}
+
+// loadItems replaces the stored values filled in by copyItems with their
+// caller-facing form, dropping those that cannot be decoded.
+func (c *cache[K, V]) loadItems(m map[K]V) {
+	if c.decode == nil && !c.copyOnGet {
+		return
+	}
+	for k, x := range m {
+		if v, ok := c.load(k, x); ok {
+			m[k] = v
+		} else {
+			delete(m, k)
+		}
+	}
+}
+
 // Returns the number of items in the cache. This may include items that have
 // expired, but have not yet been cleaned up.
 func (c *cache[K, V]) Len() int {
@@ -318,28 +1826,95 @@ func (c *cache[K, V]) Len() int {
 	return n
 }
 
+// IsEmpty reports whether the cache holds no unexpired items. Unlike checking
+// Len, it ignores expired items that have not been cleaned up yet, and it
+// stops at the first unexpired item.
+func (c *cache[K, V]) IsEmpty() bool {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.items) == 0 {
+		return true
+	}
+	now := c.now()
+	for i := range c.items {
+		if c.alive(&c.items[i], now) {
+			return false
+		}
+	}
+	return true
+}
+
 // Vist all items from the cache.
 func (c *cache[K, V]) Foreach(fn func(k K, v V)) {
 	c.Lock()
 	for i := range c.items {
-		fn(c.items[i].key, c.items[i].value)
+		if v, ok := c.load(c.items[i].key, c.items[i].value); ok {
+			fn(c.items[i].key, v)
+		}
+	}
+	c.Unlock()
+}
+
+// ForeachSnapshot calls fn for each unexpired item in the cache. Unlike
+// Foreach, it copies the items under a brief read lock and calls fn without
+// holding any lock, so other goroutines can use the cache, and fn itself may
+// call its methods, while a slow callback runs. The price is allocating the
+// copy; changes made during the iteration are not seen by it.
+func (c *cache[K, V]) ForeachSnapshot(fn func(k K, v V)) {
+	it := c.Iterator()
+	for it.Next() {
+		fn(it.Key(), it.Value())
+	}
+}
+
+// Range calls fn for each unexpired item in the cache. If fn returns false,
+// Range stops the iteration. The items are snapshotted under a read lock and
+// the lock is not held while fn runs, so fn may call other methods of the
+// cache; changes made after the snapshot is taken are not seen. Unlike Get,
+// visiting an item does not count as reading it: it does not reset its idle
+// time or add to its hits, and it does not call onMiss or refresh stale items.
+func (c *cache[K, V]) Range(fn func(k K, v V) bool) {
+	it := c.Iterator()
+	for it.Next() {
+		if !fn(it.Key(), it.Value()) {
+			return
+		}
 	}
+}
+
+// Invalidate makes every item currently in the cache count as missing, in
+// constant time: each item records the cache's generation when it is set, and
+// Invalidate starts a new one. Invalidated items are treated as missing by
+// Get, GetPointer, GetWithExpiration, Contains and Add, and make room like
+// expired items; they are deleted, with onEvicted, when the janitor or
+// DeleteExpired next runs. Until then Len and methods that list items, such
+// as Keys, may still include them. Use Purge to drop them immediately.
+func (c *cache[K, V]) Invalidate() {
+	c.Lock()
+	c.gen++
 	c.Unlock()
 }
 
 // Delete all items from the cache.
 func (c *cache[K, V]) Purge() {
 	c.Lock()
-	var zero entry[K, V]
-	for i := range c.items {
-		c.items[i] = zero // 清空数据
+	// Drop the old backing array so a cache that once grew large does not
+	// keep that memory alive.
+	c.items = make([]entry[K, V], 0, c.initcap)
+	c.indices = c.newIndex()
+	c.tags, c.keyTags = nil, nil
+	for k := range c.watchers {
+		c.closeWatchers(k)
 	}
-	c.items = c.items[:0]
-	c.indices = make(map[K]int)
 	c.Unlock()
 }
 
 func (c *cache[K, V]) run(interval time.Duration) {
+	defer atomic.AddInt64(&activeJanitors, -1)
+	if c.cleanupMax > 0 {
+		c.runAdaptive(interval)
+		return
+	}
 	ticker := time.NewTicker(interval)
 	for {
 		select {
@@ -352,36 +1927,158 @@ func (c *cache[K, V]) run(interval time.Duration) {
 	}
 }
 
+// runAdaptive is the janitor loop for WithAdaptiveCleanup.
+func (c *cache[K, V]) runAdaptive(interval time.Duration) {
+	if interval <= 0 {
+		interval = c.cleanupMax
+	}
+	interval = c.clampInterval(interval)
+	timer := time.NewTimer(interval)
+	for {
+		select {
+		case <-timer.C:
+			deleted, total := c.deleteExpired()
+			interval = c.nextInterval(interval, deleted, total)
+			timer.Reset(interval)
+		case <-c.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// nextInterval returns the adaptive janitor's next interval after a pass that
+// deleted deleted of total items: twice as long if nothing expired, half as
+// long if at least a quarter of the items had expired, and otherwise the
+// same, kept within the configured bounds.
+func (c *cache[K, V]) nextInterval(interval time.Duration, deleted, total int) time.Duration {
+	switch {
+	case deleted == 0:
+		interval *= 2
+	case deleted*4 >= total:
+		interval /= 2
+	}
+	return c.clampInterval(interval)
+}
+
+// clampInterval bounds interval to the adaptive janitor's [min, max].
+func (c *cache[K, V]) clampInterval(interval time.Duration) time.Duration {
+	if interval < c.cleanupMin {
+		interval = c.cleanupMin
+	}
+	if interval > c.cleanupMax {
+		interval = c.cleanupMax
+	}
+	return interval
+}
+
+// Close stops the cache's janitor goroutine, if it has one, and the eviction
+// goroutine of WithAsyncEvictions. Without Close they are only stopped once
+// the cache is garbage collected. After Close every write fails, including
+// changes to expiration times, so that nothing is kept that would never be
+// cleaned up or evicted: depending on its results it returns ErrClosed,
+// reports that nothing was written or just does nothing, and reads that would
+// renew an item return it unchanged. Reads and deletes keep working. It is
+// safe to call Close more than once.
+func (c *cache[K, V]) Close() {
+	c.closeOnce.Do(func() {
+		c.Lock()
+		c.closed = true
+		c.Unlock()
+		close(c.stop)
+	})
+}
+
+// activeJanitors counts the janitor goroutines that are currently running.
+var activeJanitors int64
+
+// DrainAndClose stops the cache like Close and deletes every item, expired
+// or not, calling onEvicted for each as Delete would. It is meant for graceful
+// shutdown, when values holding resources such as connections or files must
+// be released. The callbacks are called synchronously, after the lock is
+// released, so they have all returned when DrainAndClose does.
+func (c *cache[K, V]) DrainAndClose() {
+	c.Close()
+	c.Lock()
+	items := c.items
+	c.items = make([]entry[K, V], 0, c.initcap)
+	c.indices = c.newIndex()
+	c.tags, c.keyTags = nil, nil
+	for k := range c.watchers {
+		c.closeWatchers(k)
+	}
+	f := c.onEvicted
+	c.Unlock()
+	if f == nil {
+		return
+	}
+	for i := range items {
+		c.callEvicted(f, items[i].key, items[i].value)
+	}
+}
+
+// ActiveJanitors returns the number of janitor goroutines currently running
+// across all caches. It can be used in tests and monitoring to detect caches
+// that were never closed.
+func ActiveJanitors() int {
+	return int(atomic.LoadInt64(&activeJanitors))
+}
+
+// defaultInitCap is the initial capacity used when New or NewCOW is given a
+// capacity of zero or less.
+const defaultInitCap = 16
+
 func newCache[K comparable, V any](initcap int, de time.Duration) *cache[K, V] {
-	if de == 0 {
-		de = -1
+	// Normalize every non-positive default to NoExpiration, so that the
+	// stored default is never the DefaultExpiration sentinel itself and
+	// expiration only has to resolve DefaultExpiration once.
+	if de <= 0 {
+		de = NoExpiration
+	}
+	if initcap <= 0 {
+		initcap = defaultInitCap
 	}
 	c := &cache[K, V]{
+		id:                atomic.AddUint64(&lastCacheID, 1),
 		defaultExpiration: de,
+		initcap:           initcap,
 		items:             make([]entry[K, V], 0, initcap),
-		indices:           make(map[K]int),
+		indices:           make(map[K]int, initcap),
 		stop:              make(chan struct{}),
+		now:               nanotime,
 	}
 	return c
 }
 
-func newCacheWithJanitor[K comparable, V any](initcap int, de time.Duration, ci time.Duration) *Cache[K, V] {
+func newCacheWithJanitor[K comparable, V any](initcap int, de time.Duration, ci time.Duration, opts []Option[K, V]) *Cache[K, V] {
 	c := newCache[K, V](initcap, de)
+	for _, opt := range opts {
+		opt(c)
+	}
 	C := &Cache[K, V]{c}
-	if ci > 0 {
+	janitor := ci > 0 || c.cleanupMax > 0
+	if janitor {
+		atomic.AddInt64(&activeJanitors, 1)
 		go c.run(ci)
+	}
+	if c.evictQueue != nil {
+		go c.dispatchEvictions()
+	}
+	if (janitor || c.evictQueue != nil) && !c.manualLifecycle {
 		runtime.SetFinalizer(C, func(C *Cache[K, V]) {
-			close(C.cache.stop)
+			C.cache.Close()
 		})
 	}
 	return C
 }
 
 // Return a new cache with a given default expiration duration and cleanup
-// interval. If the expiration duration is less than one (or NoExpiration),
-// the items in the cache never expire (by default), and must be deleted
-// manually. If the cleanup interval is less than one, expired items are not
-// deleted from the cache before calling c.DeleteExpired().
-func New[K comparable, V any](initcap int, defaultExpiration, cleanupInterval time.Duration) *Cache[K, V] {
-	return newCacheWithJanitor[K, V](initcap, defaultExpiration, cleanupInterval)
+// interval. If the expiration duration is less than one (DefaultExpiration or
+// NoExpiration), the default is NoExpiration: items set with
+// DefaultExpiration never expire, and must be deleted manually. If the
+// cleanup interval is less than one, expired items are not deleted from the
+// cache before calling c.DeleteExpired(). Optional behaviour can be enabled
+// by passing one or more Options.
+func New[K comparable, V any](initcap int, defaultExpiration, cleanupInterval time.Duration, opts ...Option[K, V]) *Cache[K, V] {
+	return newCacheWithJanitor[K, V](initcap, defaultExpiration, cleanupInterval, opts)
 }
@@ -1,13 +1,34 @@
 package simplecache
 
 import (
+	"context"
+	"errors"
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// fakeClock is a manually advanced clock for tests that need to control
+// expiration. Install it with tc.now = clock.now.
+type fakeClock struct {
+	n int64
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{n: time.Now().UnixNano()}
+}
+
+func (f *fakeClock) now() int64 {
+	return atomic.LoadInt64(&f.n)
+}
+
+func (f *fakeClock) Add(d time.Duration) {
+	atomic.AddInt64(&f.n, int64(d))
+}
+
 type TestStruct struct {
 	Num      int
 	Children []*TestStruct
@@ -66,6 +87,26 @@ func TestCache(t *testing.T) {
 	}
 }
 
+func TestGetTyped(t *testing.T) {
+	tc := New[string, any](100, DefaultExpiration, 0)
+	tc.Set("s", "foo", DefaultExpiration)
+	tc.Set("n", 3, DefaultExpiration)
+	tc.Set("p", &TestStruct{Num: 1}, DefaultExpiration)
+
+	if v, ok := GetTyped[string](tc, "s"); !ok || v != "foo" {
+		t.Error("string was not returned:", v, ok)
+	}
+	if v, ok := GetTyped[*TestStruct](tc, "p"); !ok || v.Num != 1 {
+		t.Error("*TestStruct was not returned:", v, ok)
+	}
+	if v, ok := GetTyped[string](tc, "n"); ok || v != "" {
+		t.Error("int was returned as a string:", v, ok)
+	}
+	if v, ok := GetTyped[int](tc, "missing"); ok || v != 0 {
+		t.Error("missing key was found:", v, ok)
+	}
+}
+
 func TestGetPointer(t *testing.T) {
 	tc := New[string, int](100, 50*time.Millisecond, 1*time.Millisecond)
 	tc.Set("a", 1, DefaultExpiration)
@@ -79,6 +120,33 @@ func TestGetPointer(t *testing.T) {
 
 }
 
+func TestDefaultExpirationSemantics(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		def, d     time.Duration
+		wantExpire bool
+	}{
+		{"zero default, DefaultExpiration", 0, DefaultExpiration, false},
+		{"zero default, NoExpiration", 0, NoExpiration, false},
+		{"negative default, DefaultExpiration", -5 * time.Second, DefaultExpiration, false},
+		{"negative default, NoExpiration", -5 * time.Second, NoExpiration, false},
+		{"positive default, DefaultExpiration", time.Minute, DefaultExpiration, true},
+		{"positive default, NoExpiration", time.Minute, NoExpiration, false},
+	} {
+		tc := New[string, int](0, tt.def, 0)
+		clock := newFakeClock()
+		tc.now = clock.now
+		if tt.def <= 0 && tc.defaultExpiration != NoExpiration {
+			t.Errorf("%s: default stored as %v, want NoExpiration", tt.name, tc.defaultExpiration)
+		}
+		tc.Set("a", 1, tt.d)
+		clock.Add(time.Hour)
+		if _, found := tc.Get("a"); found == tt.wantExpire {
+			t.Errorf("%s: found is %v after an hour", tt.name, found)
+		}
+	}
+}
+
 func TestCacheTimes(t *testing.T) {
 	var found bool
 
@@ -117,6 +185,97 @@ func TestCacheTimes(t *testing.T) {
 	}
 }
 
+func TestContains(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	if !tc.Contains("a") {
+		t.Error("a was not found")
+	}
+	if tc.Contains("b") {
+		t.Error("expired b was found")
+	}
+	if tc.Contains("c") {
+		t.Error("missing c was found")
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	values, found := tc.GetBatch([]string{"a", "x", "b", "a", "c"})
+	wantValues := []int{1, 0, 2, 1, 0}
+	wantFound := []bool{true, false, true, true, false}
+	for i := range wantValues {
+		if values[i] != wantValues[i] || found[i] != wantFound[i] {
+			t.Errorf("index %d: got (%d, %v), expected (%d, %v)", i, values[i], found[i], wantValues[i], wantFound[i])
+		}
+	}
+	if values, found := tc.GetBatch(nil); len(values) != 0 || len(found) != 0 {
+		t.Error("empty batch returned results")
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Nanosecond)
+	<-time.After(time.Millisecond)
+	if v := tc.GetOrDefault("a", 10); v != 1 {
+		t.Error("expected the cached value 1, got", v)
+	}
+	if v := tc.GetOrDefault("b", 10); v != 10 {
+		t.Error("expected the default for an expired item, got", v)
+	}
+	if v := tc.GetOrDefault("c", 10); v != 10 {
+		t.Error("expected the default for a missing item, got", v)
+	}
+	if tc.Contains("c") {
+		t.Error("the default was stored in the cache")
+	}
+}
+
+func TestGetErr(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	if x, err := tc.GetErr("a"); err != nil || x != 1 {
+		t.Error("a is", x, err)
+	}
+	_, err := tc.GetErr("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("expected ErrNotFound, got", err)
+	}
+	if err.Error() != "simplecache: key not found: missing" {
+		t.Error("unexpected error message:", err)
+	}
+}
+
+func TestMaxTTL(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithMaxTTL[string, int](time.Hour))
+	clock := newFakeClock()
+	tc.now = clock.now
+	want := time.Unix(0, clock.now()).Add(time.Hour)
+
+	tc.Set("a", 1, 24*time.Hour)
+	tc.Set("b", 2, NoExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	tc.Add("d", 4, 48*time.Hour)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if _, exp, _ := tc.GetWithExpiration(k); !exp.Equal(want) {
+			t.Errorf("expiration of %s was not capped: %v", k, exp)
+		}
+	}
+	tc.Set("e", 5, time.Minute)
+	if _, exp, _ := tc.GetWithExpiration("e"); !exp.Equal(time.Unix(0, clock.now()).Add(time.Minute)) {
+		t.Error("expiration shorter than the cap was changed:", exp)
+	}
+}
+
 func TestStorePointerToStruct(t *testing.T) {
 	tc := New[string, *TestStruct](100, DefaultExpiration, 0)
 	tc.Set("foo", &TestStruct{Num: 1}, DefaultExpiration)
@@ -135,6 +294,27 @@ func TestStorePointerToStruct(t *testing.T) {
 	}
 }
 
+func TestValueCopier(t *testing.T) {
+	copySlice := func(b []int) []int {
+		return append([]int(nil), b...)
+	}
+	for _, onGet := range []bool{false, true} {
+		tc := New(100, DefaultExpiration, 0, WithValueCopier[string](copySlice, onGet))
+		v := []int{1, 2, 3}
+		tc.Set("a", v, DefaultExpiration)
+		v[0] = 100
+		got, _ := tc.Get("a")
+		if got[0] != 1 {
+			t.Errorf("onGet %v: mutating the set value changed the cached one", onGet)
+		}
+		got[1] = 200
+		got, _ = tc.Get("a")
+		if onGet && got[1] != 2 {
+			t.Error("mutating a returned value changed the cached one")
+		}
+	}
+}
+
 func TestAdd(t *testing.T) {
 	tc := New[string, string](100, DefaultExpiration, 0)
 	err := tc.Add("foo", "bar", DefaultExpiration)
@@ -147,6 +327,76 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+func TestSwap(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	if old, had, _ := tc.Swap("a", 1, time.Second); had || old != 0 {
+		t.Error("Swap of a new key returned", old, had)
+	}
+	if old, had, _ := tc.Swap("a", 2, time.Second); !had || old != 1 {
+		t.Error("expected to displace 1, got", old, had)
+	}
+	if x, _ := tc.Get("a"); x != 2 {
+		t.Error("a is", x)
+	}
+	clock.Add(2 * time.Second)
+	if old, had, _ := tc.Swap("a", 3, DefaultExpiration); had || old != 0 {
+		t.Error("Swap of an expired key returned", old, had)
+	}
+	if x, _ := tc.Get("a"); x != 3 {
+		t.Error("a is", x)
+	}
+}
+
+func TestRefusedWritesReported(t *testing.T) {
+	tc := New(10, DefaultExpiration, 0, WithMaxItems[string, int](1), WithOverflowPolicy[string, int](RejectNew))
+	tc.Set("a", 1, DefaultExpiration)
+	if old, had, err := tc.Swap("b", 2, DefaultExpiration); err != ErrCapacity || had || old != 0 {
+		t.Error("Swap into a full cache returned", old, had, err)
+	}
+	if err := tc.SetUntil("b", 2, time.Now().Add(time.Minute)); err != ErrCapacity {
+		t.Error("expected ErrCapacity from SetUntil, got", err)
+	}
+	if err := tc.SetCtx(context.Background(), "b", 2); err != ErrCapacity {
+		t.Error("expected ErrCapacity from SetCtx, got", err)
+	}
+	if tc.Contains("b") {
+		t.Error("a refused write was stored")
+	}
+	if old, had, err := tc.Swap("a", 3, DefaultExpiration); err != nil || !had || old != 1 {
+		t.Error("Swap of an existing key returned", old, had, err)
+	}
+}
+
+func TestReplaceIfStale(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Minute)
+	tc.Set("forever", 1, NoExpiration)
+
+	if tc.ReplaceIfStale("a", 2, time.Minute, 10*time.Second) {
+		t.Error("fresh item was replaced")
+	}
+	if tc.ReplaceIfStale("forever", 2, time.Minute, 10*time.Second) {
+		t.Error("never-expiring item was replaced")
+	}
+	clock.Add(55 * time.Second)
+	if !tc.ReplaceIfStale("a", 3, time.Minute, 10*time.Second) {
+		t.Error("stale item was not replaced")
+	}
+	if x, _ := tc.Get("a"); x != 3 {
+		t.Error("a is", x)
+	}
+	if remaining := tc.items[tc.indices["a"]].Expiration - clock.now(); remaining != int64(time.Minute) {
+		t.Error("replaced item has the wrong expiration:", time.Duration(remaining))
+	}
+	if !tc.ReplaceIfStale("missing", 4, time.Minute, 10*time.Second) {
+		t.Error("missing item was not set")
+	}
+}
+
 func TestDelete(t *testing.T) {
 	tc := New[string, string](100, DefaultExpiration, 0)
 	tc.Set("foo", "bar", DefaultExpiration)
@@ -160,6 +410,106 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestSortedKeys(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	for _, k := range []string{"d", "b", "e", "a", "c"} {
+		tc.Set(k, 0, DefaultExpiration)
+	}
+	// Shuffles the internal order
+	tc.Delete("b")
+	tc.Set("b", 0, DefaultExpiration)
+
+	less := func(a, b string) bool { return a < b }
+	want := "abcde"
+	for i := 0; i < 3; i++ {
+		ks := tc.SortedKeys(less)
+		got := ""
+		for _, k := range ks {
+			got += k
+		}
+		if got != want {
+			t.Fatalf("SortedKeys returned %q, want %q", got, want)
+		}
+	}
+}
+
+func TestKeysPage(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	for i := 0; i < 25; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	tc.Set(-1, -1, time.Nanosecond)
+	clock.Add(time.Millisecond)
+
+	seen := make(map[int]bool)
+	pages := 0
+	for offset := 0; ; offset += 10 {
+		ks, total := tc.KeysPage(offset, 10)
+		if total != 25 {
+			t.Fatal("expected a total of 25, got", total)
+		}
+		if len(ks) == 0 {
+			break
+		}
+		pages++
+		for _, k := range ks {
+			if seen[k] || k < 0 {
+				t.Fatal("unexpected key:", k)
+			}
+			seen[k] = true
+		}
+	}
+	if pages != 3 || len(seen) != 25 {
+		t.Errorf("expected 25 keys in 3 pages, got %d in %d", len(seen), pages)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Delete("a")
+	tc.Remove("b")
+	tc.Remove("missing")
+	if tc.Len() != 0 {
+		t.Error("expected an empty cache, got", tc.Keys())
+	}
+	if len(evicted) != 2 || evicted[1] != "b" {
+		t.Error("Remove did not call onEvicted like Delete:", evicted)
+	}
+}
+
+func TestTidy(t *testing.T) {
+	clock := newFakeClock()
+	var evicted [2][]string
+	for i, clean := range []func(*Cache[string, int]){
+		(*Cache[string, int]).DeleteExpired,
+		(*Cache[string, int]).Tidy,
+	} {
+		tc := New[string, int](100, DefaultExpiration, 0)
+		tc.now = clock.now
+		tc.OnEvicted(func(k string, v int) {
+			evicted[i] = append(evicted[i], k)
+		})
+		tc.Set("a", 1, time.Nanosecond)
+		tc.Set("b", 2, DefaultExpiration)
+		clock.Add(time.Millisecond)
+		clean(tc)
+		if tc.Len() != 1 || !tc.Contains("b") {
+			t.Error("unexpected keys after cleanup:", tc.Keys())
+		}
+	}
+	if len(evicted[1]) != 1 || evicted[0][0] != evicted[1][0] {
+		t.Error("Tidy and DeleteExpired evicted different items:", evicted)
+	}
+}
+
 func TestItemCount(t *testing.T) {
 	tc := New[string, string](100, DefaultExpiration, 0)
 	tc.Set("foo", "1", DefaultExpiration)
@@ -170,6 +520,26 @@ func TestItemCount(t *testing.T) {
 	}
 }
 
+func TestIsEmpty(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	if !tc.IsEmpty() {
+		t.Error("new cache is not empty")
+	}
+	tc.Set("a", 1, time.Second)
+	if tc.IsEmpty() {
+		t.Error("cache with an unexpired item is empty")
+	}
+	clock.Add(2 * time.Second)
+	if !tc.IsEmpty() {
+		t.Error("cache with only expired items is not empty")
+	}
+	if tc.Len() != 1 {
+		t.Error("IsEmpty removed the expired item")
+	}
+}
+
 func TestFlush(t *testing.T) {
 	tc := New[string, string](100, DefaultExpiration, 0)
 	tc.Set("foo", "bar", DefaultExpiration)
@@ -191,6 +561,84 @@ func TestFlush(t *testing.T) {
 	}
 }
 
+func TestName(t *testing.T) {
+	if n := New[string, int](0, DefaultExpiration, 0).Name(); n != "" {
+		t.Errorf("unnamed cache has name %q", n)
+	}
+	tc := New(0, DefaultExpiration, 0, WithName[string, int]("sessions"))
+	if n := tc.Name(); n != "sessions" {
+		t.Errorf("expected name %q, got %q", "sessions", n)
+	}
+}
+
+func TestNewInitcap(t *testing.T) {
+	for _, initcap := range []int{-10, 0} {
+		tc := New[string, int](initcap, DefaultExpiration, 0)
+		if n := cap(tc.items); n != defaultInitCap {
+			t.Errorf("initcap %d: expected capacity %d, got %d", initcap, defaultInitCap, n)
+		}
+		tc.Set("a", 1, DefaultExpiration)
+		tc.Purge()
+		tc.Set("b", 2, DefaultExpiration)
+		if v, ok := tc.Get("b"); !ok || v != 2 {
+			t.Errorf("initcap %d: cache is unusable", initcap)
+		}
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Hour)
+	tc.Invalidate()
+	tc.Set("c", 3, DefaultExpiration)
+
+	for _, k := range []string{"a", "b"} {
+		if _, found := tc.Get(k); found {
+			t.Error(k, "was found after Invalidate")
+		}
+		if tc.Contains(k) {
+			t.Error(k, "is contained after Invalidate")
+		}
+	}
+	if x, found := tc.Get("c"); !found || x != 3 {
+		t.Error("c was not found:", x, found)
+	}
+	if err := tc.Add("a", 4, DefaultExpiration); err != nil {
+		t.Error("Add of an invalidated key failed:", err)
+	}
+	if x, found := tc.Get("a"); !found || x != 4 {
+		t.Error("a was not set again:", x, found)
+	}
+
+	tc.DeleteExpired()
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("expected b to be reclaimed, got", evicted)
+	}
+	if tc.Len() != 2 {
+		t.Error("expected 2 items, got", tc.Keys())
+	}
+}
+
+func TestPurgeShrinks(t *testing.T) {
+	tc := New[string, int](10, DefaultExpiration, 0)
+	for i := 0; i < 10000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	tc.Purge()
+	if n := cap(tc.items); n != 10 {
+		t.Errorf("items capacity after Purge is %d, expected 10", n)
+	}
+	tc.Set("foo", 1, DefaultExpiration)
+	if v, ok := tc.Get("foo"); !ok || v != 1 {
+		t.Error("cache is unusable after Purge")
+	}
+}
+
 func TestOnEvicted(t *testing.T) {
 	tc := New[string, int](100, DefaultExpiration, 0)
 	tc.Set("foo", 3, DefaultExpiration)
@@ -214,6 +662,91 @@ func TestOnEvicted(t *testing.T) {
 	}
 }
 
+func TestWithOnEvicted(t *testing.T) {
+	evicted := make(chan string, 1)
+	tc := New(100, DefaultExpiration, time.Millisecond, WithOnEvicted(func(k string, v int) {
+		evicted <- k
+	}))
+	defer tc.Close()
+	tc.RLock()
+	installed := tc.onEvicted != nil
+	tc.RUnlock()
+	if !installed {
+		t.Fatal("onEvicted was not installed by New")
+	}
+	tc.Set("foo", 1, time.Nanosecond)
+	select {
+	case k := <-evicted:
+		if k != "foo" {
+			t.Error("unexpected evicted key:", k)
+		}
+	case <-time.After(time.Second):
+		t.Error("janitor eviction was not reported")
+	}
+}
+
+func TestAdaptiveCleanup(t *testing.T) {
+	// The bounds are long enough that the real janitor never runs a pass
+	// during the test; the passes below are driven by hand.
+	tc := New(100, DefaultExpiration, 0, WithAdaptiveCleanup[int, int](time.Hour, 8*time.Hour))
+	defer tc.Close()
+	clock := newFakeClock()
+	tc.now = clock.now
+	for i := 0; i < 10; i++ {
+		tc.Set(i, i, NoExpiration)
+	}
+
+	pass := func(interval time.Duration) time.Duration {
+		deleted, total := tc.deleteExpired()
+		return tc.nextInterval(interval, deleted, total)
+	}
+	d := time.Hour
+	// Quiet passes lengthen the interval up to max
+	for _, want := range []time.Duration{2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 8 * time.Hour} {
+		if d = pass(d); d != want {
+			t.Fatalf("expected %v after a quiet pass, got %v", want, d)
+		}
+	}
+
+	// A burst of expirations shortens it
+	for i := 10; i < 20; i++ {
+		tc.Set(i, i, time.Second)
+	}
+	clock.Add(2 * time.Second)
+	if d = pass(d); d != 4*time.Hour {
+		t.Fatal("expected 4h after a busy pass, got", d)
+	}
+
+	// A trickle keeps it unchanged
+	tc.Set(20, 20, time.Second)
+	clock.Add(2 * time.Second)
+	if d = pass(d); d != 4*time.Hour {
+		t.Fatal("expected 4h after a pass with few expirations, got", d)
+	}
+
+	// It never drops below min
+	for i := 0; i < 5; i++ {
+		d = tc.nextInterval(d, 10, 10)
+	}
+	if d != time.Hour {
+		t.Error("expected the interval to stop at 1h, got", d)
+	}
+}
+
+func BenchmarkNewWithFinalizer(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New[string, int](0, DefaultExpiration, time.Hour).Close()
+	}
+}
+
+func BenchmarkNewWithManualLifecycle(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		New(0, DefaultExpiration, time.Hour, WithManualLifecycle[string, int]()).Close()
+	}
+}
+
 func BenchmarkCacheGetExpiring(b *testing.B) {
 	benchmarkCacheGet(b, 5*time.Minute)
 }
@@ -456,6 +989,70 @@ func BenchmarkDeleteExpiredLoop(b *testing.B) {
 	}
 }
 
+func TestCleanupBatch(t *testing.T) {
+	tc := New(0, NoExpiration, 0, WithCleanupBatch[int, int](10))
+	clock := newFakeClock()
+	tc.now = clock.now
+	for i := 0; i < 100; i++ {
+		tc.Set(i, i, time.Second)
+	}
+	tc.Set(-1, -1, NoExpiration)
+	clock.Add(time.Minute)
+
+	// The clock is read again each time the cleanup takes the lock back;
+	// on the first of those, check that Get can run in between.
+	var calls int32
+	found := false
+	tc.now = func() int64 {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			done := make(chan bool)
+			go func() {
+				_, ok := tc.Get(-1)
+				done <- ok
+			}()
+			select {
+			case found = <-done:
+			case <-time.After(time.Second):
+				t.Error("Get was blocked by the cleanup")
+			}
+		}
+		return clock.now()
+	}
+	tc.DeleteExpired()
+	if !found {
+		t.Error("Get did not run during the cleanup")
+	}
+	if n := tc.Len(); n != 1 {
+		t.Errorf("got %d items after cleanup, expected 1", n)
+	}
+}
+
+func benchmarkCacheLoad(b *testing.B, hinted bool) {
+	b.StopTimer()
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		tc := New[string, int](len(keys), NoExpiration, 0)
+		if !hinted {
+			tc.indices = make(map[string]int)
+		}
+		for j, k := range keys {
+			tc.Set(k, j, DefaultExpiration)
+		}
+	}
+}
+
+func BenchmarkCacheLoadHintedIndex(b *testing.B) {
+	benchmarkCacheLoad(b, true)
+}
+
+func BenchmarkCacheLoadUnhintedIndex(b *testing.B) {
+	benchmarkCacheLoad(b, false)
+}
+
 func TestGetAndRewarnal(t *testing.T) {
 	tc := New[string, interface{}](100, 10*time.Second, time.Second)
 
@@ -475,6 +1072,110 @@ func TestGetAndRewarnal(t *testing.T) {
 
 }
 
+func TestGetResetTTL(t *testing.T) {
+	tc := New[string, int](100, time.Minute, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("session", 1, DefaultExpiration)
+	tc.Set("short", 2, time.Second)
+	for i := 0; i < 5; i++ {
+		clock.Add(45 * time.Second)
+		if _, ok := tc.GetResetTTL("session"); !ok {
+			t.Fatalf("session expired despite access %d", i)
+		}
+	}
+	_, exp, _ := tc.GetWithExpiration("session")
+	if want := time.Unix(0, clock.now()).Add(time.Minute); !exp.Equal(want) {
+		t.Error("expiration was not reset to the default:", exp, want)
+	}
+	if _, ok := tc.GetResetTTL("short"); ok {
+		t.Error("expired item was revived")
+	}
+	clock.Add(2 * time.Minute)
+	if _, ok := tc.Get("session"); ok {
+		t.Error("session did not expire without access")
+	}
+}
+
+func TestGetAndTouch(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Second)
+	clock.Add(500 * time.Millisecond)
+
+	x, renewed, ok := tc.GetAndTouch("a", time.Minute)
+	if !ok || !renewed || x != 1 {
+		t.Error("GetAndTouch returned", x, renewed, ok)
+	}
+	clock.Add(30 * time.Second)
+	if _, found := tc.Get("a"); !found {
+		t.Error("a was not renewed")
+	}
+	clock.Add(time.Minute)
+	if x, renewed, ok := tc.GetAndTouch("a", time.Minute); ok || renewed {
+		t.Error("GetAndTouch of an expired item returned", x, renewed, ok)
+	}
+	if _, renewed, ok := tc.GetAndTouch("missing", time.Minute); ok || renewed {
+		t.Error("GetAndTouch of a missing item returned", renewed, ok)
+	}
+}
+
+func TestGetOrExtend(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Minute)
+	tc.Set("b", 2, NoExpiration)
+
+	// Above the threshold: nothing changes
+	v, extended, ok := tc.GetOrExtend("a", 30*time.Second, 5*time.Minute)
+	if !ok || v != 1 || extended {
+		t.Error("unexpected result above the threshold:", v, extended, ok)
+	}
+
+	// Below the threshold: the expiration is set to now+extendTo
+	clock.Add(45 * time.Second)
+	v, extended, ok = tc.GetOrExtend("a", 30*time.Second, 5*time.Minute)
+	if !ok || v != 1 || !extended {
+		t.Error("unexpected result below the threshold:", v, extended, ok)
+	}
+	_, exp, _ := tc.GetWithExpiration("a")
+	if want := time.Unix(0, clock.now()).Add(5 * time.Minute); !exp.Equal(want) {
+		t.Error("expiration was not extended:", exp, want)
+	}
+
+	if _, extended, ok := tc.GetOrExtend("b", time.Hour, time.Hour); !ok || extended {
+		t.Error("never-expiring item was extended")
+	}
+	clock.Add(10 * time.Minute)
+	if _, _, ok := tc.GetOrExtend("a", time.Hour, time.Hour); ok {
+		t.Error("expired item was found")
+	}
+}
+
+func TestGetOrExtendInterpretsDuration(t *testing.T) {
+	tc := New(100, 5*time.Minute, 0, WithMaxTTL[string, int](time.Hour))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("capped", 1, time.Second)
+	tc.Set("default", 2, time.Second)
+	now := time.Unix(0, clock.now())
+
+	if _, extended, _ := tc.GetOrExtend("capped", time.Minute, 24*time.Hour); !extended {
+		t.Error("capped was not extended")
+	}
+	if _, exp, _ := tc.GetWithExpiration("capped"); !exp.Equal(now.Add(time.Hour)) {
+		t.Errorf("capped expires at %v, expected it capped at %v", exp, now.Add(time.Hour))
+	}
+	if _, extended, _ := tc.GetOrExtend("default", time.Minute, DefaultExpiration); !extended {
+		t.Error("default was not extended")
+	}
+	if _, exp, ok := tc.GetWithExpiration("default"); !ok || !exp.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("default expires at %v, %v, expected the default expiration %v", exp, ok, now.Add(5*time.Minute))
+	}
+}
+
 func TestCache_DeleteExpired(t *testing.T) {
 	// Create a cache with 3 items, one of which is already expired
 	cache := New[string, interface{}](100, 10*time.Second, time.Second)
@@ -629,3 +1330,1593 @@ func TestGetWithExpiration(t *testing.T) {
 		t.Error("expiration for e is in the past")
 	}
 }
+
+func TestRange(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	for i := 0; i < 10; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	tc.Set("expired", -1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	n := 0
+	tc.Range(func(k string, v int) bool {
+		if k == "expired" {
+			t.Error("Range visited an expired item")
+		}
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("Range did not stop early: visited %d items", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tc.Range(func(k string, v int) bool {
+			tc.Set(k, v+1, DefaultExpiration)
+			tc.Delete("expired")
+			return true
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Range deadlocked on a re-entrant call")
+	}
+	if v, _ := tc.Get("0"); v != 1 {
+		t.Error("re-entrant Set inside Range was not applied; value:", v)
+	}
+}
+
+func TestRangeDoesNotRecordAccess(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithIdleExpiration[string, int](time.Minute))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, NoExpiration)
+	for i := 0; i < 5; i++ {
+		clock.Add(50 * time.Second)
+		tc.Range(func(k string, v int) bool { return true })
+	}
+	if tc.Contains("a") {
+		t.Error("Range kept an idle item alive")
+	}
+
+	tc.Set("b", 2, NoExpiration)
+	tc.Range(func(k string, v int) bool { return true })
+	if _, hits, _ := tc.GetWithHits("b"); hits != 1 {
+		t.Errorf("got %d hits after Range, expected only the GetWithHits read", hits)
+	}
+}
+
+func TestServeStale(t *testing.T) {
+	loads := make(chan string, 1)
+	fail := int32(0)
+	tc := New[string, int](100, time.Minute, 0, WithServeStale(30*time.Second, func(k string) (int, error) {
+		defer func() { loads <- k }()
+		if atomic.LoadInt32(&fail) == 1 {
+			return 0, errors.New("load failed")
+		}
+		return 2, nil
+	}))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, DefaultExpiration)
+
+	// Fresh
+	clock.Add(50 * time.Second)
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Fatal("fresh item was not served:", v, ok)
+	}
+	select {
+	case <-loads:
+		t.Fatal("loader was called for a fresh item")
+	default:
+	}
+
+	// Stale: the old value is served and a refresh is triggered
+	clock.Add(20 * time.Second)
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Fatal("stale item was not served:", v, ok)
+	}
+	if k := <-loads; k != "a" {
+		t.Fatal("loader was called for the wrong key:", k)
+	}
+	// Wait for the refreshed value to be stored
+	for i := 0; i < 100; i++ {
+		if v, _ := tc.Get("a"); v == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if v, ok := tc.Get("a"); !ok || v != 2 {
+		t.Fatal("refreshed value was not stored:", v, ok)
+	}
+
+	// Expired: a failing refresh keeps the stale value until staleFor elapses
+	atomic.StoreInt32(&fail, 1)
+	clock.Add(70 * time.Second)
+	if v, ok := tc.Get("a"); !ok || v != 2 {
+		t.Fatal("stale item was not served:", v, ok)
+	}
+	<-loads
+	clock.Add(30 * time.Second)
+	if _, ok := tc.Get("a"); ok {
+		t.Fatal("item was served after the stale window elapsed")
+	}
+	tc.DeleteExpired()
+	if n := tc.Len(); n != 0 {
+		t.Fatal("expired item was not deleted:", n)
+	}
+}
+
+func TestExpireBefore(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+	now := time.Now()
+	tc.Set("a", 1, time.Minute)
+	tc.Set("b", 2, time.Hour)
+	tc.Set("c", 3, NoExpiration)
+
+	if n := tc.ExpireBefore(now); n != 0 {
+		t.Error("expected nothing to expire before now, got", n)
+	}
+	if n := tc.ExpireBefore(now.Add(30 * time.Minute)); n != 1 {
+		t.Error("expected 1 item to expire, got", n)
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Error("expected a to be evicted, got", evicted)
+	}
+	if n := tc.ExpireBefore(now.Add(24 * time.Hour)); n != 1 {
+		t.Error("expected 1 item to expire, got", n)
+	}
+	if tc.Len() != 1 || !tc.Contains("c") {
+		t.Error("unexpected keys:", tc.Keys())
+	}
+}
+
+func TestDrainExpired(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	evicted := 0
+	tc.OnEvicted(func(string, int) { evicted++ })
+	tc.Set("a", 1, time.Second)
+	tc.Set("b", 2, 2*time.Second)
+	tc.Set("c", 3, time.Minute)
+	tc.Set("d", 4, NoExpiration)
+	expA := time.Unix(0, clock.now()+int64(time.Second))
+
+	clock.Add(3 * time.Second)
+	items := tc.DrainExpired()
+	if len(items) != 2 {
+		t.Fatalf("expected 2 drained items, got %d", len(items))
+	}
+	sum := 0
+	for _, it := range items {
+		sum += it.Object
+		if it.Object == 1 && !it.Expiration.Equal(expA) {
+			t.Error("drained item has the wrong expiration:", it.Expiration)
+		}
+	}
+	if sum != 3 {
+		t.Error("drained the wrong items:", items)
+	}
+	if n := tc.Len(); n != 2 {
+		t.Error("expected 2 items left, got", n)
+	}
+	if _, ok := tc.Get("c"); !ok {
+		t.Error("c was drained but it has not expired")
+	}
+	if evicted != 0 {
+		t.Error("onEvicted was called by DrainExpired")
+	}
+	if items := tc.DrainExpired(); len(items) != 0 {
+		t.Error("second drain returned items:", items)
+	}
+}
+
+func TestCopyTo(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	for i := 0; i < 50; i++ {
+		tc.Set(i, i*2, DefaultExpiration)
+	}
+	tc.Set(-1, -1, time.Second)
+	clock.Add(2 * time.Second)
+
+	dst := map[int]int{1000: 0}
+	tc.CopyTo(dst)
+	if len(dst) != 50 {
+		t.Fatal("expected 50 items, got", len(dst))
+	}
+	for i := 0; i < 50; i++ {
+		if dst[i] != i*2 {
+			t.Errorf("dst[%d] = %d", i, dst[i])
+		}
+	}
+
+	if n := testing.AllocsPerRun(10, func() { tc.CopyTo(dst) }); n != 0 {
+		t.Error("CopyTo into a reused map allocated", n, "times")
+	}
+}
+
+func TestIterator(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	for i := 0; i < 100; i++ {
+		tc.Set(i, i*2, DefaultExpiration)
+	}
+
+	it := tc.Iterator()
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			tc.Delete(i)
+			tc.Set(i+100, i, DefaultExpiration)
+		}
+		close(done)
+	}()
+
+	seen := make(map[int]bool)
+	for it.Next() {
+		if it.Value() != it.Key()*2 {
+			t.Errorf("wrong value %d for key %d", it.Value(), it.Key())
+		}
+		seen[it.Key()] = true
+	}
+	<-done
+	if len(seen) != 100 {
+		t.Errorf("expected 100 items in the snapshot, got %d", len(seen))
+	}
+	if it.Next() {
+		t.Error("Next returned true after the iterator was exhausted")
+	}
+}
+
+func TestStream(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	n := 3*streamBatch + 1
+	for i := 0; i < n; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	tc.Set(-1, -1, time.Second)
+	tc.Set(-2, -2, time.Nanosecond)
+	clock.Add(time.Millisecond)
+
+	seen := make(map[int]bool)
+	for it := range tc.Stream(context.Background()) {
+		if it.Key != it.Value || seen[it.Key] {
+			t.Fatal("unexpected item:", it)
+		}
+		seen[it.Key] = true
+		if it.Key == -1 && it.Expiration.IsZero() {
+			t.Error("expiration of -1 is missing")
+		}
+		if it.Key >= 0 && !it.Expiration.IsZero() {
+			t.Error("non-expiring item has an expiration:", it)
+		}
+	}
+	if len(seen) != n+1 || seen[-2] {
+		t.Errorf("expected %d unexpired items, got %d", n+1, len(seen))
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	for i := 0; i < 10*streamBatch; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := tc.Stream(ctx)
+	for i := 0; i < 10; i++ {
+		<-ch
+	}
+	cancel()
+
+	got := 10
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if got >= 10*streamBatch {
+					t.Error("stream was not stopped by cancel")
+				}
+				return
+			}
+			got++
+		case <-timeout:
+			t.Fatal("stream was not closed after cancel")
+		}
+	}
+}
+
+func TestKeysChan(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	for i := 0; i < 3*streamBatch; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	tc.Set(-1, -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	seen := make(map[int]bool)
+	for k := range tc.KeysChan(context.Background()) {
+		if seen[k] {
+			t.Error("key sent twice:", k)
+		}
+		seen[k] = true
+	}
+	if len(seen) != 3*streamBatch || seen[-1] {
+		t.Errorf("got %d keys, expected %d unexpired ones", len(seen), 3*streamBatch)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := tc.KeysChan(ctx)
+	<-ch
+	cancel()
+	got := 1
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				if got >= 3*streamBatch {
+					t.Error("stream was not stopped by cancel")
+				}
+				return
+			}
+			got++
+		case <-timeout:
+			t.Fatal("stream was not closed after cancel")
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.SetWithTags("a", 1, DefaultExpiration, "tenant:1")
+	tc.SetWithTags("b", 2, DefaultExpiration, "tenant:1", "hot")
+	tc.SetWithTags("c", 3, DefaultExpiration, "tenant:2", "hot")
+	tc.SetWithTags("d", 4, time.Second, "tenant:1")
+	tc.Set("e", 5, DefaultExpiration)
+
+	clock.Add(2 * time.Second)
+	if n := tc.InvalidateTag("tenant:1"); n != 2 {
+		t.Errorf("expected 2 live items invalidated, got %d", n)
+	}
+	for _, k := range []string{"a", "b", "d"} {
+		if _, ok := tc.Get(k); ok {
+			t.Errorf("%s was found after its tag was invalidated", k)
+		}
+	}
+	if tc.Len() != 2 {
+		t.Error("expired tagged item was not removed; len:", tc.Len())
+	}
+
+	// b was removed, so only c is left under "hot"
+	if n := tc.InvalidateTag("hot"); n != 1 {
+		t.Errorf("expected 1 item invalidated, got %d", n)
+	}
+	if _, ok := tc.Get("e"); !ok {
+		t.Error("untagged item e was removed")
+	}
+
+	// Overwriting an item without tags clears its tags
+	tc.SetWithTags("f", 6, DefaultExpiration, "tenant:3")
+	tc.Set("f", 7, DefaultExpiration)
+	if n := tc.InvalidateTag("tenant:3"); n != 0 {
+		t.Errorf("overwritten item was still tagged; invalidated %d", n)
+	}
+	if n := tc.InvalidateTag("missing"); n != 0 {
+		t.Errorf("unknown tag invalidated %d items", n)
+	}
+	if len(tc.tags) != 0 || len(tc.keyTags) != 0 {
+		t.Error("tag index was not cleaned up:", tc.tags, tc.keyTags)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	ch, stop := tc.Watch("cfg")
+	tc.Set("other", 0, DefaultExpiration)
+	tc.Set("cfg", 1, DefaultExpiration)
+	if x := <-ch; x != 1 {
+		t.Error("expected 1, got", x)
+	}
+
+	// A slow watcher only sees the latest value
+	tc.Set("cfg", 2, DefaultExpiration)
+	tc.Set("cfg", 3, DefaultExpiration)
+	if x := <-ch; x != 3 {
+		t.Error("expected 3, got", x)
+	}
+	select {
+	case x := <-ch:
+		t.Error("unexpected value", x)
+	default:
+	}
+
+	tc.Delete("cfg")
+	if _, ok := <-ch; ok {
+		t.Error("channel was not closed on delete")
+	}
+	stop() // must not close the channel again
+
+	ch, stop = tc.Watch("cfg")
+	stop()
+	if _, ok := <-ch; ok {
+		t.Error("channel was not closed on unsubscribe")
+	}
+	tc.Set("cfg", 4, DefaultExpiration)
+	if len(tc.watchers) != 0 {
+		t.Error("watcher was not removed:", tc.watchers)
+	}
+}
+
+func TestMapView(t *testing.T) {
+	tc := New[string, int](100, time.Minute, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	var kv KV[string, int] = tc.MapView()
+	kv.Set("a", 1)
+	if x, found := kv.Get("a"); !found || x != 1 {
+		t.Error("a is", x, found)
+	}
+	if x, _ := tc.Get("a"); x != 1 {
+		t.Error("value set through the view is not in the cache")
+	}
+	kv.Set("b", 2)
+	clock.Add(2 * time.Minute)
+	if _, found := kv.Get("b"); found {
+		t.Error("value set through the view did not use the default expiration")
+	}
+	kv.Delete("a")
+	if tc.Contains("a") {
+		t.Error("a was not deleted")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	ro := tc.ReadOnly()
+	if ro.Len() != 0 {
+		t.Error("view of an empty cache has items")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		ro.Get(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	if ro.Len() != 100 || len(ro.Keys()) != 100 {
+		t.Error("view does not see the writes:", ro.Len())
+	}
+	if x, found := ro.Get("42"); !found || x != 42 {
+		t.Error("42 is", x, found)
+	}
+	tc.Delete("42")
+	if ro.Contains("42") {
+		t.Error("view does not see the delete")
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	tc := New[string, string](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+
+	var acquired int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if tc.AcquireLock("job", strconv.Itoa(i), time.Minute) {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if acquired != 1 {
+		t.Fatalf("expected exactly one holder, got %d", acquired)
+	}
+	holder, _ := tc.Get("job")
+
+	// The lease expires and someone else takes the lock
+	clock.Add(2 * time.Minute)
+	if !tc.AcquireLock("job", "late", time.Minute) {
+		t.Fatal("lock was not acquired after the lease expired")
+	}
+	if tc.ReleaseLock("job", holder) {
+		t.Error("the expired holder released someone else's lock")
+	}
+	if !tc.ReleaseLock("job", "late") {
+		t.Error("the current holder could not release the lock")
+	}
+	if tc.ReleaseLock("job", "late") {
+		t.Error("an already released lock was released again")
+	}
+	if !tc.AcquireLock("job", "next", time.Minute) {
+		t.Error("lock was not acquired after it was released")
+	}
+}
+
+func TestEvictOnGet(t *testing.T) {
+	for _, evict := range []bool{false, true} {
+		tc := New(100, DefaultExpiration, 0, WithEvictOnGet[string, int](evict))
+		clock := newFakeClock()
+		tc.now = clock.now
+		var evicted []string
+		tc.OnEvicted(func(k string, v int) {
+			evicted = append(evicted, k)
+		})
+		tc.Set("a", 1, time.Second)
+		tc.Set("b", 2, time.Minute)
+		clock.Add(2 * time.Second)
+
+		if _, found := tc.Get("a"); found {
+			t.Fatal("expired item was found")
+		}
+		tc.Get("b")
+		tc.Get("missing")
+		wantLen := 2
+		if evict {
+			wantLen = 1
+			if len(evicted) != 1 || evicted[0] != "a" {
+				t.Error("expected a to be evicted, got", evicted)
+			}
+		}
+		if tc.Len() != wantLen {
+			t.Errorf("evict %v: expected %d items, got %v", evict, wantLen, tc.Keys())
+		}
+	}
+}
+
+func TestOnMiss(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	misses := map[string]int{}
+	tc.OnMiss(func(k string) {
+		misses[k]++
+		// The lock must not be held while the callback runs
+		tc.Set("seen", 1, DefaultExpiration)
+	})
+	tc.Set("a", 1, time.Second)
+	tc.Get("a")
+	tc.Get("b")
+	tc.GetPointer("b")
+	tc.GetWithExpiration("b")
+	clock.Add(2 * time.Second)
+	tc.Get("a")
+	tc.GetPointer("a")
+	tc.GetWithExpiration("a")
+	if misses["a"] != 3 || misses["b"] != 3 || len(misses) != 2 {
+		t.Error("unexpected misses:", misses)
+	}
+}
+
+func TestTouchMany(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Second)
+	tc.Set("b", 2, time.Minute)
+	tc.Set("c", 3, NoExpiration)
+	tc.Set("d", 4, time.Millisecond)
+	clock.Add(10 * time.Millisecond)
+
+	if n := tc.TouchMany([]string{"a", "b", "c", "d", "missing"}, time.Hour); n != 3 {
+		t.Errorf("expected 3 keys touched, got %d", n)
+	}
+	want := time.Unix(0, clock.now()).Add(time.Hour)
+	for _, k := range []string{"a", "b", "c"} {
+		if _, exp, ok := tc.GetWithExpiration(k); !ok || !exp.Equal(want) {
+			t.Errorf("expiration of %s was not updated: %v", k, exp)
+		}
+	}
+	if _, ok := tc.Get("d"); ok {
+		t.Error("expired key d was revived by TouchMany")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	for _, overwrite := range []bool{false, true} {
+		dst := New[string, int](100, DefaultExpiration, 0)
+		src := New[string, int](100, DefaultExpiration, 0)
+		clock := newFakeClock()
+		dst.now = clock.now
+		src.now = clock.now
+		dst.Set("a", 1, DefaultExpiration)
+		dst.Set("b", 2, DefaultExpiration)
+		src.Set("b", 20, time.Minute)
+		src.Set("c", 30, time.Minute)
+		src.Set("d", 40, time.Second)
+		clock.Add(2 * time.Second)
+
+		wantN := 1
+		if overwrite {
+			wantN = 2
+		}
+		if n := dst.Merge(src, overwrite); n != wantN {
+			t.Errorf("overwrite=%v: merged %d items, expected %d", overwrite, n, wantN)
+		}
+		if v, _ := dst.Get("a"); v != 1 {
+			t.Error("a was changed by the merge:", v)
+		}
+		wantB := 2
+		if overwrite {
+			wantB = 20
+		}
+		if v, _ := dst.Get("b"); v != wantB {
+			t.Errorf("overwrite=%v: expected b=%d, got %d", overwrite, wantB, v)
+		}
+		v, exp, ok := dst.GetWithExpiration("c")
+		if !ok || v != 30 {
+			t.Error("c was not merged:", v, ok)
+		}
+		if _, srcExp, _ := src.GetWithExpiration("c"); !exp.Equal(srcExp) {
+			t.Error("remaining TTL of c was not preserved:", exp, srcExp)
+		}
+		if _, ok := dst.Get("d"); ok {
+			t.Error("expired d was merged")
+		}
+	}
+}
+
+func TestMergeLimits(t *testing.T) {
+	clock := newFakeClock()
+	dst := New(100, DefaultExpiration, 0, WithMaxTTL[string, int](time.Minute),
+		WithMaxItems[string, int](2), WithOverflowPolicy[string, int](RejectNew))
+	src := New[string, int](100, DefaultExpiration, 0)
+	dst.now = clock.now
+	src.now = clock.now
+	src.Set("a", 1, time.Hour)
+	src.Set("b", 2, NoExpiration)
+	src.Set("c", 3, time.Hour)
+	if n := dst.Merge(src, true); n != 2 {
+		t.Error("expected 2 items to fit, merged", n)
+	}
+	now := time.Unix(0, clock.now())
+	for _, k := range dst.Keys() {
+		if _, exp, _ := dst.GetWithExpiration(k); !exp.Equal(now.Add(time.Minute)) {
+			t.Errorf("%s expires at %v, expected it capped at %v", k, exp, now.Add(time.Minute))
+		}
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	a := New[int, int](100, DefaultExpiration, 0)
+	b := New[int, int](100, DefaultExpiration, 0)
+	for i := 0; i < 100; i++ {
+		a.Set(i, i, DefaultExpiration)
+		b.Set(i+100, i, DefaultExpiration)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() { a.Merge(b, true); wg.Done() }()
+		go func() { b.Merge(a, true); wg.Done() }()
+	}
+	wg.Wait()
+	if a.Len() != 200 || b.Len() != 200 {
+		t.Error("merge did not copy all items:", a.Len(), b.Len())
+	}
+}
+
+func TestGetWithTTL(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Minute)
+	tc.Set("b", 2, NoExpiration)
+	clock.Add(15 * time.Second)
+
+	if v, ttl, ok := tc.GetWithTTL("a"); !ok || v != 1 || ttl != 45*time.Second {
+		t.Error("GetWithTTL(a) returned", v, ttl, ok)
+	}
+	if v, ttl, ok := tc.GetWithTTL("b"); !ok || v != 2 || ttl != NoExpiration {
+		t.Error("GetWithTTL(b) returned", v, ttl, ok)
+	}
+	clock.Add(time.Minute)
+	if _, _, ok := tc.GetWithTTL("a"); ok {
+		t.Error("expired item was found")
+	}
+	if _, _, ok := tc.GetWithTTL("missing"); ok {
+		t.Error("missing item was found")
+	}
+}
+
+func TestGetWithCreated(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	setAt := time.Unix(0, clock.now())
+	tc.Set("a", 1, time.Minute)
+	clock.Add(time.Second)
+	tc.Get("a")
+	v, created, ok := tc.GetWithCreated("a")
+	if !ok || v != 1 || !created.Equal(setAt) {
+		t.Error("GetWithCreated returned", v, created, ok, "want creation time", setAt)
+	}
+	if _, _, ok := tc.GetWithCreated("missing"); ok {
+		t.Error("missing key was found")
+	}
+}
+
+func TestAgeBounds(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	if _, _, ok := tc.AgeBounds(); ok {
+		t.Error("AgeBounds reported bounds for an empty cache")
+	}
+
+	start := time.Unix(0, clock.now())
+	tc.Set("a", 1, DefaultExpiration)
+	clock.Add(time.Second)
+	tc.Set("b", 2, DefaultExpiration)
+	clock.Add(time.Second)
+	tc.Set("c", 3, time.Second)
+	clock.Add(2 * time.Second)
+
+	oldest, newest, ok := tc.AgeBounds()
+	if !ok || !oldest.Equal(start) || !newest.Equal(start.Add(time.Second)) {
+		t.Error("unexpected bounds:", oldest, newest, ok)
+	}
+
+	_, created, exp, ok := tc.GetWithTimes("b")
+	if !ok || !created.Equal(start.Add(time.Second)) || !exp.IsZero() {
+		t.Error("unexpected times for b:", created, exp, ok)
+	}
+	tc.Set("a", 10, time.Minute)
+	_, created, exp, _ = tc.GetWithTimes("a")
+	if now := time.Unix(0, clock.now()); !created.Equal(now) || !exp.Equal(now.Add(time.Minute)) {
+		t.Error("overwriting a did not update its times:", created, exp)
+	}
+	if _, _, _, ok := tc.GetWithTimes("c"); ok {
+		t.Error("expired c was found")
+	}
+}
+
+func TestActiveJanitors(t *testing.T) {
+	before := ActiveJanitors()
+	var cs []*Cache[string, int]
+	var scs []*ShardedCache[int]
+	for i := 0; i < 50; i++ {
+		cs = append(cs, New[string, int](0, DefaultExpiration, time.Hour))
+		scs = append(scs, NewSharded[int](DefaultExpiration, time.Hour, 4))
+	}
+	if n := ActiveJanitors(); n < 100 {
+		t.Fatalf("expected at least 100 running janitors, got %d", n)
+	}
+	for i := range cs {
+		cs[i].Close()
+		cs[i].Close()
+		scs[i].Close()
+	}
+	for i := 0; i < 100 && ActiveJanitors() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := ActiveJanitors(); n > before {
+		t.Errorf("janitors leaked: %d running, %d before", n, before)
+	}
+}
+
+func TestMaxItems(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithMaxItems[string, int](3))
+	clock := newFakeClock()
+	tc.now = clock.now
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+
+	tc.Set("a", 1, DefaultExpiration)
+	clock.Add(time.Millisecond)
+	tc.Set("b", 2, time.Second)
+	clock.Add(time.Millisecond)
+	tc.Set("c", 3, DefaultExpiration)
+	clock.Add(2 * time.Second)
+
+	// b has expired, so it is removed instead of the oldest live item a
+	tc.Set("d", 4, DefaultExpiration)
+	if tc.Len() != 3 || len(evicted) != 1 || evicted[0] != "b" {
+		t.Fatal("expected b to be evicted, got", evicted)
+	}
+	if _, ok := tc.Get("a"); !ok {
+		t.Error("live item a was evicted while an expired one could be reclaimed")
+	}
+
+	// No item has expired, so the oldest one is evicted
+	tc.Set("e", 5, DefaultExpiration)
+	if tc.Len() != 3 || len(evicted) != 2 || evicted[1] != "a" {
+		t.Error("expected a to be evicted, got", evicted)
+	}
+
+	// Overwriting an existing key does not evict anything
+	tc.Set("e", 6, DefaultExpiration)
+	if len(evicted) != 2 {
+		t.Error("overwrite caused an eviction:", evicted)
+	}
+}
+
+func TestMaxItemsReclaimsAllExpired(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithMaxItems[string, int](4))
+	clock := newFakeClock()
+	tc.now = clock.now
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+
+	tc.Set("live", 1, DefaultExpiration)
+	tc.Set("x", 2, time.Second)
+	tc.Set("y", 3, time.Second)
+	tc.Set("z", 4, time.Second)
+	clock.Add(2 * time.Second)
+
+	// All three expired items are reclaimed by the first insert, so the
+	// following ones fit without evicting the oldest live item.
+	tc.Set("a", 5, DefaultExpiration)
+	tc.Set("b", 6, DefaultExpiration)
+	tc.Set("c", 7, DefaultExpiration)
+	if len(evicted) != 3 {
+		t.Error("expected the 3 expired items to be evicted, got", evicted)
+	}
+	if _, found := tc.Get("live"); !found {
+		t.Error("live item was evicted while expired items held slots")
+	}
+	if n := tc.Len(); n != 4 {
+		t.Error("expected 4 items, got", n)
+	}
+}
+
+func TestMaxItemsSamplesForEviction(t *testing.T) {
+	tc := New(1000, DefaultExpiration, 0, WithMaxItems[int, int](1000), WithEvictionSamples[int, int](8))
+	clock := newFakeClock()
+	tc.now = clock.now
+	var evicted []int
+	tc.OnEvicted(func(k int, v int) {
+		evicted = append(evicted, k)
+	})
+
+	for i := 0; i < 990; i++ {
+		tc.Set(i, i, time.Second)
+	}
+	for i := 990; i < 1000; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	clock.Add(2 * time.Second)
+
+	// Each insert looks at 8 items at most, reclaiming the expired ones
+	// among them; a sample of only live items is all but impossible with
+	// 99% of the items expired.
+	tc.Set(1000, 1000, DefaultExpiration)
+	if len(evicted) == 0 || len(evicted) > 8 {
+		t.Fatal("expected between 1 and 8 evictions, got", len(evicted))
+	}
+	for _, k := range evicted {
+		if k >= 990 {
+			t.Error("live item", k, "was evicted while sampled expired items held slots")
+		}
+	}
+	if _, found := tc.Get(1000); !found {
+		t.Error("new item was not added")
+	}
+}
+
+func BenchmarkCacheSetFull(b *testing.B) {
+	b.StopTimer()
+	const n = 100000
+	tc := New(n, DefaultExpiration, 0, WithMaxItems[int, int](n))
+	for i := 0; i < n; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		tc.Set(n+i, i, DefaultExpiration)
+	}
+}
+
+func TestSetTx(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithMaxItems[string, int](4), WithOverflowPolicy[string, int](RejectNew))
+	errNegative := errors.New("negative value")
+	nonNegative := func(k string, v int) error {
+		if v < 0 {
+			return errNegative
+		}
+		return nil
+	}
+
+	if err := tc.SetTx(map[string]int{"a": 1, "b": 2, "c": 3}, DefaultExpiration, nonNegative); err != nil {
+		t.Fatal("SetTx failed:", err)
+	}
+	if tc.Len() != 3 {
+		t.Error("expected 3 items, got", tc.Keys())
+	}
+
+	err := tc.SetTx(map[string]int{"a": 10, "d": 4, "e": -5}, DefaultExpiration, nonNegative)
+	if err != errNegative {
+		t.Error("expected the validation error, got", err)
+	}
+	if x, _ := tc.Get("a"); x != 1 || tc.Contains("d") {
+		t.Error("failed SetTx applied some items")
+	}
+
+	// Two new keys do not fit in the one free slot
+	if err := tc.SetTx(map[string]int{"a": 10, "d": 4, "e": 5}, DefaultExpiration, nil); err != ErrCapacity {
+		t.Error("expected ErrCapacity, got", err)
+	}
+	if x, _ := tc.Get("a"); x != 1 || tc.Len() != 3 {
+		t.Error("SetTx over capacity applied some items")
+	}
+	if err := tc.SetTx(map[string]int{"a": 10, "d": 4}, DefaultExpiration, nil); err != nil {
+		t.Error("SetTx within capacity failed:", err)
+	}
+	if x, _ := tc.Get("a"); x != 10 || !tc.Contains("d") {
+		t.Error("SetTx did not apply all items")
+	}
+}
+
+func TestSetTxEvictOldest(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithMaxItems[string, int](3))
+	clock := newFakeClock()
+	tc.now = clock.now
+	for _, k := range []string{"a", "b", "c"} {
+		tc.Set(k, 1, DefaultExpiration)
+		clock.Add(time.Millisecond)
+	}
+
+	if err := tc.SetTx(map[string]int{"d": 4, "e": 5, "f": 6, "g": 7}, DefaultExpiration, nil); err != ErrCapacity {
+		t.Error("expected ErrCapacity for a batch larger than the cache, got", err)
+	}
+	if tc.Len() != 3 || !tc.Contains("a") {
+		t.Error("oversized SetTx changed the cache:", tc.Keys())
+	}
+
+	if err := tc.SetTx(map[string]int{"d": 4, "e": 5}, DefaultExpiration, nil); err != nil {
+		t.Fatal("SetTx failed:", err)
+	}
+	if !tc.Contains("c") || !tc.Contains("d") || !tc.Contains("e") || tc.Len() != 3 {
+		t.Error("expected the oldest items to make room for the whole batch, got", tc.Keys())
+	}
+}
+
+func TestSetTxKeepsBatchKeys(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithMaxItems[string, int](3))
+	clock := newFakeClock()
+	tc.now = clock.now
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+	for _, k := range []string{"a", "b", "c"} {
+		tc.Set(k, 1, DefaultExpiration)
+		clock.Add(time.Millisecond)
+	}
+
+	// a is the oldest item, but evicting it would not make room since the
+	// batch sets it again.
+	if err := tc.SetTx(map[string]int{"a": 2, "d": 4}, DefaultExpiration, nil); err != nil {
+		t.Fatal("SetTx failed:", err)
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Error("expected only b to be evicted, got", evicted)
+	}
+	if x, _ := tc.Get("a"); x != 2 || !tc.Contains("c") || !tc.Contains("d") || tc.Len() != 3 {
+		t.Error("unexpected items after SetTx:", tc.Keys())
+	}
+}
+
+func TestWriteValidator(t *testing.T) {
+	errTooBig := errors.New("value too big")
+	tc := New(100, DefaultExpiration, 0, WithWriteValidator(func(k string, v int) error {
+		if v > 10 {
+			return errTooBig
+		}
+		return nil
+	}))
+
+	if err := tc.SetValidated("a", 1, DefaultExpiration); err != nil {
+		t.Error("valid write was rejected:", err)
+	}
+	if err := tc.SetValidated("a", 11, DefaultExpiration); err != errTooBig {
+		t.Error("expected the validator's error, got", err)
+	}
+	if err := tc.Add("b", 12, DefaultExpiration); err != errTooBig {
+		t.Error("expected the validator's error from Add, got", err)
+	}
+	tc.Set("c", 13, DefaultExpiration)
+	tc.SetWithTags("d", 14, DefaultExpiration, "t")
+	if x, _ := tc.Get("a"); x != 1 {
+		t.Error("rejected write replaced a:", x)
+	}
+	if tc.Len() != 1 {
+		t.Error("rejected writes were stored:", tc.Keys())
+	}
+	if err := tc.Add("e", 5, DefaultExpiration); err != nil {
+		t.Error("valid Add was rejected:", err)
+	}
+}
+
+func TestOverflowPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{EvictOldest, RejectNew} {
+		tc := New(100, DefaultExpiration, 0, WithMaxItems[string, int](2), WithOverflowPolicy[string, int](policy))
+		clock := newFakeClock()
+		tc.now = clock.now
+		tc.Set("a", 1, DefaultExpiration)
+		clock.Add(time.Millisecond)
+		tc.Set("b", 2, DefaultExpiration)
+		clock.Add(time.Millisecond)
+
+		err := tc.Add("c", 3, DefaultExpiration)
+		tc.Set("d", 4, DefaultExpiration)
+		// Overwriting an existing key is never rejected
+		tc.Set("b", 5, DefaultExpiration)
+		if x, _ := tc.Get("b"); x != 5 {
+			t.Errorf("policy %d: overwrite of b failed", policy)
+		}
+
+		switch policy {
+		case EvictOldest:
+			if err != nil {
+				t.Error("EvictOldest: Add returned", err)
+			}
+			if tc.Contains("a") || tc.Contains("c") || !tc.Contains("d") {
+				t.Error("EvictOldest: unexpected keys:", tc.Keys())
+			}
+		case RejectNew:
+			if !errors.Is(err, ErrCapacity) {
+				t.Error("RejectNew: expected ErrCapacity from Add, got", err)
+			}
+			if !tc.Contains("a") || tc.Contains("c") || tc.Contains("d") {
+				t.Error("RejectNew: unexpected keys:", tc.Keys())
+			}
+			// An expired item is still reclaimed to make room
+			tc.Delete("b")
+			tc.Set("x", 0, time.Nanosecond)
+			clock.Add(time.Millisecond)
+			if err := tc.Add("e", 6, DefaultExpiration); err != nil {
+				t.Error("RejectNew: expired item was not reclaimed:", err)
+			}
+		}
+	}
+}
+
+func TestOnEvictedPanic(t *testing.T) {
+	var errs []error
+	tc := New(100, DefaultExpiration, 0, WithErrorHandler[string, int](func(err error) {
+		errs = append(errs, err)
+	}))
+	clock := newFakeClock()
+	tc.now = clock.now
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		if k == "b" {
+			panic("boom")
+		}
+		evicted = append(evicted, k)
+	})
+	tc.Set("a", 1, time.Second)
+	tc.Set("b", 2, time.Second)
+	tc.Set("c", 3, time.Second)
+	clock.Add(2 * time.Second)
+
+	tc.DeleteExpired()
+	if len(evicted) != 2 {
+		t.Error("callbacks after the panicking one did not run:", evicted)
+	}
+	if len(errs) != 1 {
+		t.Fatal("expected one reported error, got", errs)
+	}
+	if tc.Len() != 0 {
+		t.Error("expired items were not all deleted")
+	}
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Delete("b")
+	if len(errs) != 2 {
+		t.Error("panic in Delete was not reported:", errs)
+	}
+}
+
+func TestIdleTime(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithAccessTracking[string, int]())
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Second)
+
+	clock.Add(3 * time.Second)
+	if d, ok := tc.IdleTime("a"); !ok || d != 3*time.Second {
+		t.Error("idle time did not grow:", d, ok)
+	}
+	tc.Get("a")
+	if d, _ := tc.IdleTime("a"); d != 0 {
+		t.Error("idle time was not reset by Get:", d)
+	}
+	clock.Add(time.Second)
+	if d, _ := tc.IdleTime("a"); d != time.Second {
+		t.Error("unexpected idle time:", d)
+	}
+	if _, ok := tc.IdleTime("b"); ok {
+		t.Error("expired b has an idle time")
+	}
+	if _, ok := tc.IdleTime("c"); ok {
+		t.Error("missing c has an idle time")
+	}
+}
+
+func TestReadPathsRecordAccess(t *testing.T) {
+	for name, read := range map[string]func(tc *Cache[string, int]) bool{
+		"Get":               func(tc *Cache[string, int]) bool { _, ok := tc.Get("a"); return ok },
+		"GetBatch":          func(tc *Cache[string, int]) bool { _, found := tc.GetBatch([]string{"a"}); return found[0] },
+		"GetWithExpiration": func(tc *Cache[string, int]) bool { _, _, ok := tc.GetWithExpiration("a"); return ok },
+		"GetWithTTL":        func(tc *Cache[string, int]) bool { _, _, ok := tc.GetWithTTL("a"); return ok },
+		"GetWithTimes":      func(tc *Cache[string, int]) bool { _, _, _, ok := tc.GetWithTimes("a"); return ok },
+		"GetAndTouch":       func(tc *Cache[string, int]) bool { _, _, ok := tc.GetAndTouch("a", NoExpiration); return ok },
+		"GetResetTTL":       func(tc *Cache[string, int]) bool { _, ok := tc.GetResetTTL("a"); return ok },
+		"GetOrExtend":       func(tc *Cache[string, int]) bool { _, _, ok := tc.GetOrExtend("a", 0, NoExpiration); return ok },
+		"GetAndRenewal":     func(tc *Cache[string, int]) bool { _, ok := tc.GetAndRenewal("a"); return ok },
+		"GetPointer":        func(tc *Cache[string, int]) bool { _, ok := tc.GetPointer("a"); return ok },
+		"AddOrGet":          func(tc *Cache[string, int]) bool { _, added := tc.AddOrGet("a", 2, NoExpiration); return !added },
+	} {
+		tc := New(100, NoExpiration, 0, WithIdleExpiration[string, int](time.Minute))
+		clock := newFakeClock()
+		tc.now = clock.now
+		tc.Set("a", 1, NoExpiration)
+		clock.Add(40 * time.Second)
+		if !read(tc) {
+			t.Errorf("%s: a was not found", name)
+			continue
+		}
+		clock.Add(40 * time.Second)
+		if !tc.Contains("a") {
+			t.Errorf("%s: the read was not recorded, and a went idle", name)
+		}
+		if _, hits, _ := tc.GetWithHits("a"); hits != 2 {
+			t.Errorf("%s: got %d hits, expected 2", name, hits)
+		}
+	}
+}
+
+func TestIdleTimeUntracked(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, DefaultExpiration)
+	clock.Add(time.Second)
+	tc.Get("a")
+	if d, ok := tc.IdleTime("a"); ok {
+		t.Error("got an idle time without access tracking:", d)
+	}
+}
+
+func TestIdleExpiration(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithIdleExpiration[string, int](time.Minute))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("idle", 1, NoExpiration)
+	tc.Set("active", 2, NoExpiration)
+	tc.Set("short", 3, 30*time.Second)
+	tc.Set("long", 4, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		clock.Add(40 * time.Second)
+		if _, ok := tc.Get("active"); !ok {
+			t.Fatal("active item expired despite being read")
+		}
+		tc.Get("long")
+	}
+	if tc.Contains("idle") {
+		t.Error("idle item is still present")
+	}
+	if _, ok := tc.Get("short"); ok {
+		t.Error("short item outlived its absolute expiration")
+	}
+	if err := tc.Add("idle", 10, DefaultExpiration); err != nil {
+		t.Error("Add failed on an idle-expired key:", err)
+	}
+	clock.Add(2 * time.Minute)
+	tc.DeleteExpired()
+	if n := tc.Len(); n != 0 {
+		t.Error("janitor did not delete idle items; len:", n)
+	}
+}
+
+func TestHottestColdest(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithAccessTracking[string, int]())
+	clock := newFakeClock()
+	tc.now = clock.now
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		tc.Set(k, 0, DefaultExpiration)
+		clock.Add(time.Second)
+	}
+	tc.Set("expired", 0, time.Nanosecond)
+	clock.Add(time.Second)
+	// Read order, oldest first: b, d, a (c and e were only set)
+	for _, k := range []string{"b", "d", "a"} {
+		tc.Get(k)
+		clock.Add(time.Second)
+	}
+
+	equal := func(got, want []string) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+	if got := tc.Hottest(2); !equal(got, []string{"a", "d"}) {
+		t.Error("unexpected hottest keys:", got)
+	}
+	if got := tc.Coldest(3); !equal(got, []string{"c", "e", "b"}) {
+		t.Error("unexpected coldest keys:", got)
+	}
+	if got := tc.Hottest(10); len(got) != 5 {
+		t.Error("expected all 5 unexpired keys, got", got)
+	}
+	if got := tc.Coldest(0); len(got) != 0 {
+		t.Error("expected no keys, got", got)
+	}
+}
+
+func TestForeachSnapshot(t *testing.T) {
+	tc := New[string, int](10, NoExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+
+	sum := 0
+	tc.ForeachSnapshot(func(k string, v int) {
+		sum += v
+		done := make(chan struct{})
+		go func() {
+			tc.Set("c"+k, v, DefaultExpiration)
+			tc.Get("a")
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("cache is locked during ForeachSnapshot callback")
+		}
+	})
+	if sum != 3 {
+		t.Errorf("got sum %d, expected 3", sum)
+	}
+	if n := tc.Len(); n != 4 {
+		t.Errorf("got %d items, expected 4", n)
+	}
+}
+
+func TestAddOrGet(t *testing.T) {
+	tc := New[string, int](10, NoExpiration, 0)
+	if v, added := tc.AddOrGet("a", 1, DefaultExpiration); !added || v != 1 {
+		t.Errorf("got %d, %v, expected 1, true", v, added)
+	}
+	if v, added := tc.AddOrGet("a", 2, DefaultExpiration); added || v != 1 {
+		t.Errorf("got %d, %v, expected 1, false", v, added)
+	}
+
+	var wg sync.WaitGroup
+	var adds int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, added := tc.AddOrGet("b", i, DefaultExpiration)
+			if added {
+				atomic.AddInt32(&adds, 1)
+			}
+			if got, _ := tc.Get("b"); got != v {
+				t.Errorf("AddOrGet returned %d, but cache holds %d", v, got)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if adds != 1 {
+		t.Errorf("got %d adds, expected 1", adds)
+	}
+}
+
+func TestGetWithHits(t *testing.T) {
+	tc := New(10, NoExpiration, 0, WithAccessTracking[string, int]())
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Get("a")
+	tc.Get("a")
+	if v, hits, ok := tc.GetWithHits("a"); !ok || v != 1 || hits != 3 {
+		t.Errorf("got %d, %d hits, %v, expected 1, 3 hits, true", v, hits, ok)
+	}
+	tc.Get("a")
+	if _, hits, _ := tc.GetWithHits("a"); hits != 5 {
+		t.Errorf("got %d hits, expected 5", hits)
+	}
+	tc.Set("a", 2, DefaultExpiration)
+	if _, hits, _ := tc.GetWithHits("a"); hits != 1 {
+		t.Errorf("got %d hits after Set, expected 1", hits)
+	}
+	if _, _, ok := tc.GetWithHits("missing"); ok {
+		t.Error("missing key was found")
+	}
+
+	untracked := New[string, int](10, NoExpiration, 0)
+	untracked.Set("a", 1, DefaultExpiration)
+	untracked.Get("a")
+	if _, hits, ok := untracked.GetWithHits("a"); !ok || hits != 2 {
+		t.Errorf("got %d hits without access tracking, expected 2", hits)
+	}
+}
+
+func TestDrainAndClose(t *testing.T) {
+	evicted := map[string]int{}
+	tc := New(10, NoExpiration, time.Hour, WithOnEvicted(func(k string, v int) {
+		evicted[k] = v
+	}))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, time.Second)
+	clock.Add(time.Minute)
+
+	tc.DrainAndClose()
+	if len(evicted) != 3 || evicted["a"] != 1 || evicted["b"] != 2 || evicted["c"] != 3 {
+		t.Error("onEvicted was not called for every item:", evicted)
+	}
+	if n := tc.Len(); n != 0 {
+		t.Errorf("got %d items after DrainAndClose, expected 0", n)
+	}
+	select {
+	case <-tc.stop:
+	default:
+		t.Error("janitor was not stopped")
+	}
+}
+
+func TestSetUntil(t *testing.T) {
+	tc := New[string, int](10, time.Hour, 0, WithMaxTTL[string, int](10*time.Minute))
+	clock := newFakeClock()
+	tc.now = clock.now
+	now := time.Unix(0, clock.now())
+
+	tc.SetUntil("a", 1, now.Add(time.Minute))
+	if _, exp, ok := tc.GetWithExpiration("a"); !ok || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("got expiration %v, %v, expected %v", exp, ok, now.Add(time.Minute))
+	}
+	tc.SetUntil("b", 1, now.Add(time.Hour))
+	if _, exp, _ := tc.GetWithExpiration("b"); !exp.Equal(now.Add(10 * time.Minute)) {
+		t.Errorf("got expiration %v, expected it capped at %v", exp, now.Add(10*time.Minute))
+	}
+	tc.SetUntil("c", 1, now.Add(-time.Second))
+	if _, ok := tc.Get("c"); ok {
+		t.Error("item with a past deadline was found")
+	}
+}
+
+func TestSetCtx(t *testing.T) {
+	tc := New[string, int](10, 5*time.Minute, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	now := time.Unix(0, clock.now())
+
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(time.Minute))
+	defer cancel()
+	tc.SetCtx(ctx, "a", 1)
+	if _, exp, ok := tc.GetWithExpiration("a"); !ok || !exp.Equal(now.Add(time.Minute)) {
+		t.Errorf("got expiration %v, %v, expected the context deadline %v", exp, ok, now.Add(time.Minute))
+	}
+
+	tc.SetCtx(context.Background(), "b", 2)
+	if _, exp, ok := tc.GetWithExpiration("b"); !ok || !exp.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("got expiration %v, %v, expected the default %v", exp, ok, now.Add(5*time.Minute))
+	}
+}
+
+func TestUpdateValue(t *testing.T) {
+	tc := New[string, int](10, NoExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Minute)
+	_, exp, _ := tc.GetWithExpiration("a")
+
+	clock.Add(30 * time.Second)
+	if !tc.UpdateValue("a", 2) {
+		t.Fatal("UpdateValue did not update an existing item")
+	}
+	v, newExp, ok := tc.GetWithExpiration("a")
+	if !ok || v != 2 {
+		t.Errorf("got %d, %v, expected 2, true", v, ok)
+	}
+	if !newExp.Equal(exp) {
+		t.Errorf("expiration changed from %v to %v", exp, newExp)
+	}
+
+	if tc.UpdateValue("missing", 1) {
+		t.Error("UpdateValue updated a missing item")
+	}
+	if _, ok := tc.Get("missing"); ok {
+		t.Error("UpdateValue added a missing item")
+	}
+	clock.Add(time.Minute)
+	if tc.UpdateValue("a", 3) {
+		t.Error("UpdateValue updated an expired item")
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	tc := New[string, int](10, NoExpiration, time.Hour)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Close()
+
+	tc.Set("c", 3, DefaultExpiration)
+	if _, ok := tc.Get("c"); ok {
+		t.Error("Set stored an item after Close")
+	}
+	if err := tc.Add("d", 4, DefaultExpiration); err != ErrClosed {
+		t.Errorf("Add after Close returned %v, expected ErrClosed", err)
+	}
+	if err := tc.SetValidated("a", 5, DefaultExpiration); err != ErrClosed {
+		t.Errorf("SetValidated after Close returned %v, expected ErrClosed", err)
+	}
+	if tc.UpdateValue("a", 6) {
+		t.Error("UpdateValue succeeded after Close")
+	}
+	if err := tc.SetTx(map[string]int{"e": 5}, DefaultExpiration, nil); err != ErrClosed {
+		t.Errorf("SetTx after Close returned %v, expected ErrClosed", err)
+	}
+	if _, renewed, ok := tc.GetAndTouch("a", time.Minute); renewed || !ok {
+		t.Errorf("GetAndTouch after Close returned renewed %v, ok %v", renewed, ok)
+	}
+	if n := tc.TouchMany([]string{"a"}, time.Minute); n != 0 {
+		t.Error("TouchMany touched items after Close")
+	}
+	if tc.Revive("a", time.Minute) {
+		t.Error("Revive succeeded after Close")
+	}
+	if _, exp, _ := tc.GetWithExpiration("a"); !exp.IsZero() {
+		t.Error("expiration was changed after Close:", exp)
+	}
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Errorf("got %d, %v after Close, expected 1, true", v, ok)
+	}
+	tc.Delete("b")
+	if _, ok := tc.Get("b"); ok {
+		t.Error("Delete did not work after Close")
+	}
+	tc.Close()
+}
+
+func TestRevive(t *testing.T) {
+	tc := New[string, int](10, NoExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Second)
+	tc.Set("b", 2, time.Second)
+
+	if v, expired, ok := tc.GetEvenIfExpired("a"); !ok || expired || v != 1 {
+		t.Errorf("got %d, %v, %v, expected 1, false, true", v, expired, ok)
+	}
+	clock.Add(time.Minute)
+	if _, ok := tc.Get("a"); ok {
+		t.Fatal("expired item was found by Get")
+	}
+	if v, expired, ok := tc.GetEvenIfExpired("a"); !ok || !expired || v != 1 {
+		t.Errorf("got %d, %v, %v, expected 1, true, true", v, expired, ok)
+	}
+
+	if !tc.Revive("a", time.Minute) {
+		t.Fatal("Revive did not find an expired item")
+	}
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Errorf("got %d, %v after Revive, expected 1, true", v, ok)
+	}
+	if _, ttl, _ := tc.GetWithTTL("a"); ttl != time.Minute {
+		t.Errorf("got TTL %v after Revive, expected %v", ttl, time.Minute)
+	}
+
+	tc.DeleteExpired()
+	if _, _, ok := tc.GetEvenIfExpired("b"); ok {
+		t.Error("GetEvenIfExpired found an item reclaimed by DeleteExpired")
+	}
+	if tc.Revive("b", time.Minute) {
+		t.Error("Revive revived an item reclaimed by DeleteExpired")
+	}
+	if _, ok := tc.Get("a"); !ok {
+		t.Error("revived item was reclaimed by DeleteExpired")
+	}
+}
+
+func TestInvalidateLookups(t *testing.T) {
+	other := New[string, int](10, NoExpiration, 0)
+	other.Set("o", 1, DefaultExpiration)
+	other.Invalidate()
+
+	tc := New[string, int](10, time.Minute, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Invalidate()
+
+	if _, ok := tc.GetResetTTL("a"); ok {
+		t.Error("GetResetTTL found an invalidated item")
+	}
+	if _, _, ok := tc.GetAndTouch("a", time.Minute); ok {
+		t.Error("GetAndTouch found an invalidated item")
+	}
+	if _, _, ok := tc.GetOrExtend("a", time.Minute, time.Minute); ok {
+		t.Error("GetOrExtend found an invalidated item")
+	}
+	if _, ok := tc.GetAndRenewal("a"); ok {
+		t.Error("GetAndRenewal found an invalidated item")
+	}
+	if _, _, _, ok := tc.GetWithTimes("a"); ok {
+		t.Error("GetWithTimes found an invalidated item")
+	}
+	if _, ok := tc.IdleTime("a"); ok {
+		t.Error("IdleTime found an invalidated item")
+	}
+	if n := tc.TouchMany([]string{"a"}, time.Minute); n != 0 {
+		t.Error("TouchMany touched an invalidated item")
+	}
+	if !tc.IsEmpty() {
+		t.Error("cache holding only invalidated items is not empty")
+	}
+	if !tc.ReplaceIfStale("a", 2, DefaultExpiration, time.Second) {
+		t.Error("ReplaceIfStale did not replace an invalidated item")
+	}
+
+	tc.Merge(other, true)
+	if _, ok := tc.Get("o"); ok {
+		t.Error("Merge copied an invalidated item")
+	}
+}
+
+func TestIdleExpirationReadPaths(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithIdleExpiration[string, int](time.Minute))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, time.Hour)
+	tc.Set("b", 2, NoExpiration)
+	clock.Add(2 * time.Minute)
+
+	for _, k := range []string{"a", "b"} {
+		if _, ok := tc.GetPointer(k); ok {
+			t.Error("GetPointer found idle item", k)
+		}
+		if _, _, ok := tc.GetWithExpiration(k); ok {
+			t.Error("GetWithExpiration found idle item", k)
+		}
+		if _, _, _, ok := tc.GetWithTimes(k); ok {
+			t.Error("GetWithTimes found idle item", k)
+		}
+		if _, ok := tc.GetResetTTL(k); ok {
+			t.Error("GetResetTTL found idle item", k)
+		}
+	}
+	if ks := tc.Keys(); len(ks) != 0 {
+		t.Error("Keys listed idle items:", ks)
+	}
+	if it := tc.Iterator(); it.Next() {
+		t.Error("Iterator returned idle item", it.Key())
+	}
+}
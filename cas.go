@@ -0,0 +1,71 @@
+package simplecache
+
+import "reflect"
+
+// WithEquality sets the function used to compare values in CompareAndSwap,
+// CompareAndDelete and ReleaseLock. By default they use reflect.DeepEqual,
+// which works for any value but is slow; for comparable value types pass
+// Equal.
+func WithEquality[K comparable, V any](equal func(a, b V) bool) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.equal = equal
+	}
+}
+
+// Equal compares two values with ==. It is a fast equality for WithEquality
+// when V is comparable.
+func Equal[V comparable](a, b V) bool {
+	return a == b
+}
+
+// valuesEqual compares two values with the cache's equality function.
+func (c *cache[K, V]) valuesEqual(a, b V) bool {
+	if c.equal != nil {
+		return c.equal(a, b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// CompareAndSwap replaces the value of an unexpired item with x if its
+// current value equals old, and reports whether it did. The item keeps its
+// expiration time. Values are compared as set by WithEquality. It also
+// returns false if the write is refused as by Set.
+func (c *cache[K, V]) CompareAndSwap(k K, old, x V) bool {
+	enc, err := c.encodeValue(x)
+	if err != nil {
+		return false
+	}
+	c.Lock()
+	cur, found := c.get(k)
+	if found {
+		cur, found = c.load(k, cur)
+	}
+	if !found || !c.valuesEqual(cur, old) {
+		c.Unlock()
+		return false
+	}
+	idx, _ := c.index(k)
+	err = c.setEncoded(k, x, enc, c.items[idx].Expiration)
+	c.unlock()
+	return err == nil
+}
+
+// CompareAndDelete deletes an unexpired item if its value equals old, and
+// reports whether it did. Values are compared as set by WithEquality.
+func (c *cache[K, V]) CompareAndDelete(k K, old V) bool {
+	c.Lock()
+	cur, found := c.get(k)
+	if found {
+		cur, found = c.load(k, cur)
+	}
+	if !found || !c.valuesEqual(cur, old) {
+		c.Unlock()
+		return false
+	}
+	v, evicted := c.delete(k)
+	c.Unlock()
+	if evicted {
+		c.notifyEvicted(c.onEvicted, k, v)
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package simplecache
+
+import (
+	"testing"
+	"time"
+)
+
+type casValue struct {
+	ID    int
+	Attrs map[string]string
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	sameID := func(a, b casValue) bool { return a.ID == b.ID }
+	tc := New(10, NoExpiration, 0, WithEquality[string](sameID))
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", casValue{ID: 1, Attrs: map[string]string{"x": "1"}}, time.Minute)
+	_, exp, _ := tc.GetWithExpiration("a")
+
+	if tc.CompareAndSwap("a", casValue{ID: 2}, casValue{ID: 3}) {
+		t.Error("CompareAndSwap swapped a value that did not match")
+	}
+	// Only the IDs are compared, so differing Attrs still match
+	if !tc.CompareAndSwap("a", casValue{ID: 1}, casValue{ID: 2}) {
+		t.Fatal("CompareAndSwap did not swap a matching value")
+	}
+	v, newExp, _ := tc.GetWithExpiration("a")
+	if v.ID != 2 {
+		t.Errorf("got ID %d after CompareAndSwap, expected 2", v.ID)
+	}
+	if !newExp.Equal(exp) {
+		t.Errorf("expiration changed from %v to %v", exp, newExp)
+	}
+	if tc.CompareAndSwap("missing", casValue{}, casValue{ID: 1}) {
+		t.Error("CompareAndSwap swapped a missing item")
+	}
+
+	if tc.CompareAndDelete("a", casValue{ID: 1}) {
+		t.Error("CompareAndDelete deleted a value that did not match")
+	}
+	if !tc.CompareAndDelete("a", casValue{ID: 2, Attrs: map[string]string{"y": "2"}}) {
+		t.Error("CompareAndDelete did not delete a matching value")
+	}
+	if _, ok := tc.Get("a"); ok {
+		t.Error("item was found after CompareAndDelete")
+	}
+}
+
+func TestCompareAndSwapDefaultEquality(t *testing.T) {
+	tc := New[string, casValue](10, NoExpiration, 0)
+	tc.Set("a", casValue{ID: 1, Attrs: map[string]string{"x": "1"}}, DefaultExpiration)
+	if tc.CompareAndSwap("a", casValue{ID: 1}, casValue{ID: 2}) {
+		t.Error("reflect.DeepEqual matched values with different Attrs")
+	}
+	if !tc.CompareAndSwap("a", casValue{ID: 1, Attrs: map[string]string{"x": "1"}}, casValue{ID: 2}) {
+		t.Error("reflect.DeepEqual did not match equal values")
+	}
+
+	ints := New(10, NoExpiration, 0, WithEquality[string](Equal[int]))
+	ints.Set("n", 1, DefaultExpiration)
+	if !ints.CompareAndSwap("n", 1, 2) || ints.CompareAndSwap("n", 1, 3) {
+		t.Error("CompareAndSwap with Equal did not compare with ==")
+	}
+}
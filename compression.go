@@ -0,0 +1,105 @@
+package simplecache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Codec compresses and decompresses cached values.
+type Codec interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// WithCompression stores []byte values compressed with codec. Values are
+// compressed when they are set and decompressed when they are read, outside
+// the cache's lock, so the cache API keeps dealing in uncompressed bytes; the
+// exception is GetPointer, which points at the compressed value. A write
+// whose value cannot be compressed is refused with the codec's error, and a
+// value that cannot be decompressed is treated as missing, with the error
+// passed to the error handler (see WithErrorHandler). Use CompressionRatio to
+// see how much memory the codec saves.
+//
+// WithCompression panics if codec is a GzipCodec with an invalid Level.
+func WithCompression[K comparable](codec Codec) Option[K, []byte] {
+	if g, ok := codec.(GzipCodec); ok {
+		if _, err := g.level(); err != nil {
+			panic(err)
+		}
+	}
+	return func(c *cache[K, []byte]) {
+		c.encode = codec.Compress
+		c.decode = codec.Decompress
+	}
+}
+
+// countCompressed adds a value stored compressed to the totals behind
+// CompressionRatio.
+func (c *cache[K, V]) countCompressed(raw, stored V) {
+	r, _ := any(raw).([]byte)
+	s, _ := any(stored).([]byte)
+	atomic.AddInt64(&c.rawBytes, int64(len(r)))
+	atomic.AddInt64(&c.storedBytes, int64(len(s)))
+}
+
+// CompressionRatio returns the total size of the compressed values written to
+// the cache divided by their uncompressed size, or 0 if nothing has been
+// compressed.
+func (c *cache[K, V]) CompressionRatio() float64 {
+	raw := atomic.LoadInt64(&c.rawBytes)
+	if raw == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&c.storedBytes)) / float64(raw)
+}
+
+// GzipCodec is a Codec using gzip at the given compression level (see the
+// compress/gzip constants; 0 means gzip.DefaultCompression).
+type GzipCodec struct {
+	Level int
+}
+
+// level returns the gzip level to compress at, or an error if Level is not
+// a valid one.
+func (g GzipCodec) level() (int, error) {
+	if g.Level == 0 {
+		return gzip.DefaultCompression, nil
+	}
+	if g.Level < gzip.HuffmanOnly || g.Level > gzip.BestCompression {
+		return 0, fmt.Errorf("simplecache: invalid gzip level %d", g.Level)
+	}
+	return g.Level, nil
+}
+
+// Compress returns b compressed with gzip, or an error if Level is invalid.
+func (g GzipCodec) Compress(b []byte) ([]byte, error) {
+	level, err := g.level()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the gzip-compressed b uncompressed, or an error if b is
+// not valid gzip data.
+func (g GzipCodec) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,104 @@
+package simplecache
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var compressible = []byte(strings.Repeat(`{"id":1,"name":"simplecache","tags":["a","b"]},`, 200))
+
+func TestCompression(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithCompression[string](GzipCodec{}))
+	evicted := 0
+	tc.OnEvicted(func(k string, v []byte) {
+		if !bytes.Equal(v, compressible) {
+			t.Error("onEvicted got a compressed value")
+		}
+		evicted++
+	})
+	tc.Set("a", compressible, DefaultExpiration)
+	if err := tc.Add("b", compressible, DefaultExpiration); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		v, ok := tc.Get(k)
+		if !ok || !bytes.Equal(v, compressible) {
+			t.Errorf("%s did not round-trip", k)
+		}
+	}
+	if p, _ := tc.GetPointer("a"); len(*p) >= len(compressible) {
+		t.Error("value was not stored compressed")
+	}
+	if r := tc.CompressionRatio(); r <= 0 || r >= 0.5 {
+		t.Error("unexpected compression ratio:", r)
+	}
+	tc.Delete("a")
+	if evicted != 1 {
+		t.Error("onEvicted was not called")
+	}
+
+	if r := New[string, []byte](0, DefaultExpiration, 0).CompressionRatio(); r != 0 {
+		t.Error("uncompressed cache reported a ratio:", r)
+	}
+}
+
+// failingCodec refuses to compress anything.
+type failingCodec struct{ GzipCodec }
+
+func (failingCodec) Compress([]byte) ([]byte, error) {
+	return nil, errors.New("no space")
+}
+
+func TestCompressionErrors(t *testing.T) {
+	var errs []error
+	tc := New(100, DefaultExpiration, 0, WithCompression[string](GzipCodec{}),
+		WithErrorHandler[string, []byte](func(err error) { errs = append(errs, err) }))
+	tc.Set("a", compressible, DefaultExpiration)
+	p, _ := tc.GetPointer("a")
+	*p = []byte("not gzip")
+	if v, ok := tc.Get("a"); ok || v != nil {
+		t.Error("corrupt value was returned:", v, ok)
+	}
+	if it := tc.Iterator(); it.Next() {
+		t.Error("corrupt value was returned by Iterator")
+	}
+	if len(errs) != 2 {
+		t.Error("expected the decoding errors to be reported, got", errs)
+	}
+
+	fc := New(100, DefaultExpiration, 0, WithCompression[string](failingCodec{}))
+	if err := fc.SetValidated("a", compressible, DefaultExpiration); err == nil || err.Error() != "no space" {
+		t.Error("expected the codec's error, got", err)
+	}
+	if fc.Contains("a") {
+		t.Error("value that failed to compress was stored")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("WithCompression accepted an invalid gzip level")
+		}
+	}()
+	WithCompression[string](GzipCodec{Level: 42})
+}
+
+func BenchmarkCompressionSet(b *testing.B) {
+	tc := New(100, DefaultExpiration, 0, WithCompression[string](GzipCodec{}))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tc.Set(strconv.Itoa(i%100), compressible, DefaultExpiration)
+	}
+	b.ReportMetric(tc.CompressionRatio(), "ratio")
+}
+
+func BenchmarkCompressionGet(b *testing.B) {
+	tc := New(100, DefaultExpiration, 0, WithCompression[string](GzipCodec{}))
+	tc.Set("a", compressible, DefaultExpiration)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tc.Get("a")
+	}
+}
@@ -0,0 +1,114 @@
+package simplecache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// COW is an experimental copy-on-write cache for read-mostly workloads. The
+// item map is published through an atomic pointer, so Get never takes a lock
+// and never contends with other readers or with writers.
+//
+// The price is write amplification: every Set and Delete copies the whole map,
+// making writes O(n) in the number of items. Writers are serialized by a mutex.
+// Use it only when writes are rare compared to reads. Expired items are
+// dropped from the map whenever it is copied.
+type COW[K comparable, V any] struct {
+	mu                sync.Mutex
+	m                 atomic.Value // map[K]cowEntry[V]
+	defaultExpiration time.Duration
+}
+
+type cowEntry[V any] struct {
+	value      V
+	expiration int64
+}
+
+// NewCOW returns a new copy-on-write cache. initcap and defaultExpiration
+// have the same meaning as for New.
+func NewCOW[K comparable, V any](initcap int, defaultExpiration time.Duration) *COW[K, V] {
+	if initcap <= 0 {
+		initcap = defaultInitCap
+	}
+	c := &COW[K, V]{defaultExpiration: defaultExpiration}
+	c.m.Store(make(map[K]cowEntry[V], initcap))
+	return c
+}
+
+// NewImmutable returns a copy-on-write cache whose items never expire by
+// default. Get on such a cache is fully lock-free and never reads the clock,
+// which suits read-heavy caches of data that rarely changes. The maps Get
+// reads are immutable; Set and Delete publish a modified copy, see COW.
+func NewImmutable[K comparable, V any]() *COW[K, V] {
+	return NewCOW[K, V](0, NoExpiration)
+}
+
+// Get an item from the cache without locking. Returns the item and a bool
+// indicating whether the key was found.
+func (c *COW[K, V]) Get(k K) (v V, ok bool) {
+	e, found := c.m.Load().(map[K]cowEntry[V])[k]
+	if !found {
+		return v, false
+	}
+	if e.expiration > 0 && time.Now().UnixNano() > e.expiration {
+		return v, false
+	}
+	return e.value, true
+}
+
+// Set an item in the cache, replacing any existing item. The duration is
+// interpreted as in Cache.Set.
+func (c *COW[K, V]) Set(k K, x V, d time.Duration) {
+	if d == DefaultExpiration {
+		d = c.defaultExpiration
+	}
+	var e int64
+	if d > 0 {
+		e = time.Now().UnixNano() + int64(d)
+	}
+	c.mu.Lock()
+	m := c.copy(1)
+	m[k] = cowEntry[V]{value: x, expiration: e}
+	c.m.Store(m)
+	c.mu.Unlock()
+}
+
+// Delete an item from the cache. Does nothing if the key is not in the cache.
+func (c *COW[K, V]) Delete(k K) {
+	c.mu.Lock()
+	if _, found := c.m.Load().(map[K]cowEntry[V])[k]; found {
+		m := c.copy(0)
+		delete(m, k)
+		c.m.Store(m)
+	}
+	c.mu.Unlock()
+}
+
+// Remove is an alias for Delete.
+//
+// Deprecated: Delete is the canonical name; use it instead.
+func (c *COW[K, V]) Remove(k K) {
+	c.Delete(k)
+}
+
+// Len returns the number of items in the cache, including expired items that
+// have not been dropped yet.
+func (c *COW[K, V]) Len() int {
+	return len(c.m.Load().(map[K]cowEntry[V]))
+}
+
+// copy returns a copy of the current map without its expired items, with
+// room for extra more. The caller must hold c.mu.
+func (c *COW[K, V]) copy(extra int) map[K]cowEntry[V] {
+	old := c.m.Load().(map[K]cowEntry[V])
+	m := make(map[K]cowEntry[V], len(old)+extra)
+	now := time.Now().UnixNano()
+	for k, e := range old {
+		if e.expiration > 0 && now > e.expiration {
+			continue
+		}
+		m[k] = e
+	}
+	return m
+}
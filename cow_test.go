@@ -0,0 +1,114 @@
+package simplecache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCOW(t *testing.T) {
+	tc := NewCOW[string, int](0, DefaultExpiration)
+	if _, ok := tc.Get("a"); ok {
+		t.Error("a was found in an empty cache")
+	}
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, time.Millisecond)
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Error("a was not found:", v, ok)
+	}
+	<-time.After(5 * time.Millisecond)
+	if _, ok := tc.Get("b"); ok {
+		t.Error("b was found after it expired")
+	}
+	tc.Set("c", 3, DefaultExpiration)
+	if n := tc.Len(); n != 2 {
+		t.Error("expired b was not dropped on write; len:", n)
+	}
+	tc.Delete("a")
+	if _, ok := tc.Get("a"); ok {
+		t.Error("a was found after Delete")
+	}
+}
+
+func TestCOWNegativeInitcap(t *testing.T) {
+	tc := NewCOW[string, int](-10, DefaultExpiration)
+	tc.Set("a", 1, DefaultExpiration)
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Error("a was not found:", v, ok)
+	}
+}
+
+func TestImmutable(t *testing.T) {
+	tc := NewImmutable[string, int]()
+	tc.Set("a", 1, DefaultExpiration)
+	e, found := tc.m.Load().(map[string]cowEntry[int])["a"]
+	if !found {
+		t.Fatal("a was not stored")
+	}
+	if e.expiration != 0 {
+		t.Error("item in an immutable cache expires by default")
+	}
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Error("a was not found:", v, ok)
+	}
+}
+
+func BenchmarkImmutableGetConcurrent(b *testing.B) {
+	tc := NewImmutable[string, int]()
+	for i := 0; i < 1000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tc.Get(strconv.Itoa(i % 1000))
+			i++
+		}
+	})
+}
+
+func BenchmarkNotExpiringCacheGetConcurrent(b *testing.B) {
+	tc := New[string, int](1000, NoExpiration, 0)
+	for i := 0; i < 1000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tc.Get(strconv.Itoa(i % 1000))
+			i++
+		}
+	})
+}
+
+func BenchmarkCOWGetConcurrent(b *testing.B) {
+	tc := NewCOW[string, int](1000, DefaultExpiration)
+	for i := 0; i < 1000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tc.Get(strconv.Itoa(i % 1000))
+			i++
+		}
+	})
+}
+
+func BenchmarkRWMutexCacheGetConcurrent(b *testing.B) {
+	tc := New[string, int](1000, DefaultExpiration, 0)
+	for i := 0; i < 1000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			tc.Get(strconv.Itoa(i % 1000))
+			i++
+		}
+	})
+}
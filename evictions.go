@@ -0,0 +1,95 @@
+package simplecache
+
+import "sync/atomic"
+
+// EvictionQueuePolicy decides what happens when an item is evicted while the
+// queue set up by WithAsyncEvictions is full.
+type EvictionQueuePolicy int
+
+const (
+	// BlockWhenFull makes the evicting goroutine wait for room in the queue,
+	// slowing eviction down to the pace of the callback.
+	BlockWhenFull EvictionQueuePolicy = iota
+	// DropWhenFull skips the callback for the item and counts it in
+	// DroppedEvictions.
+	DropWhenFull
+)
+
+// evictedItem is a queued call to an onEvicted callback.
+type evictedItem[K comparable, V any] struct {
+	f func(K, V)
+	k K
+	v V
+}
+
+// WithAsyncEvictions calls the onEvicted callback from a separate goroutine,
+// fed by a queue of up to size items, so that a slow callback does not hold
+// up the janitor or the goroutine that evicted the item. Callbacks still run
+// one at a time and in eviction order. policy decides what happens when the
+// queue is full. The goroutine stops when the cache is closed, after running
+// the callbacks already queued; callbacks for items evicted after Close are
+// called synchronously again.
+func WithAsyncEvictions[K comparable, V any](size int, policy EvictionQueuePolicy) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.evictQueue = make(chan evictedItem[K, V], size)
+		c.dropEvictions = policy == DropWhenFull
+	}
+}
+
+// DroppedEvictions returns the number of onEvicted callbacks that were skipped
+// because the queue set up by WithAsyncEvictions with DropWhenFull was full.
+func (c *cache[K, V]) DroppedEvictions() int64 {
+	return atomic.LoadInt64(&c.droppedEvictions)
+}
+
+// queueEvicted hands a callback to the eviction goroutine and reports whether
+// the caller is done with it, either because it was queued or dropped. It
+// returns false once the cache is closed, when the caller should call it.
+func (c *cache[K, V]) queueEvicted(f func(K, V), k K, v V) bool {
+	ev := evictedItem[K, V]{f, k, v}
+	// The read lock keeps the final drain in dispatchEvictions from starting
+	// until this callback is either queued or known to be the caller's.
+	c.evictMu.RLock()
+	defer c.evictMu.RUnlock()
+	select {
+	case <-c.stop:
+		return false
+	default:
+	}
+	select {
+	case c.evictQueue <- ev:
+		return true
+	default:
+	}
+	if c.dropEvictions {
+		atomic.AddInt64(&c.droppedEvictions, 1)
+		return true
+	}
+	select {
+	case c.evictQueue <- ev:
+		return true
+	case <-c.stop:
+		return false
+	}
+}
+
+// dispatchEvictions runs the queued callbacks until the cache is closed.
+func (c *cache[K, V]) dispatchEvictions() {
+	for {
+		select {
+		case ev := <-c.evictQueue:
+			c.callEvicted(ev.f, ev.k, ev.v)
+		case <-c.stop:
+			c.evictMu.Lock()
+			defer c.evictMu.Unlock()
+			for {
+				select {
+				case ev := <-c.evictQueue:
+					c.callEvicted(ev.f, ev.k, ev.v)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package simplecache
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowEvictions returns an onEvicted callback that blocks until release is
+// closed, signalling started when the first call begins.
+func slowEvictions() (f func(string, int), started, release chan struct{}, evicted *[]string, mu *sync.Mutex) {
+	started, release = make(chan struct{}), make(chan struct{})
+	evicted, mu = new([]string), new(sync.Mutex)
+	var once sync.Once
+	f = func(k string, v int) {
+		once.Do(func() { close(started) })
+		<-release
+		mu.Lock()
+		*evicted = append(*evicted, k)
+		mu.Unlock()
+	}
+	return
+}
+
+// waitEvictions waits up to a second for n callbacks to have run.
+func waitEvictions(mu *sync.Mutex, evicted *[]string, n int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		mu.Lock()
+		got := len(*evicted)
+		mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAsyncEvictionsDrop(t *testing.T) {
+	f, started, release, evicted, mu := slowEvictions()
+	tc := New(100, DefaultExpiration, 0, WithOnEvicted(f), WithAsyncEvictions[string, int](2, DropWhenFull))
+	for i := 0; i < 10; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+
+	tc.Delete("0")
+	<-started
+	// "0" is in the callback; two more fit in the queue and the rest are
+	// dropped, without Delete blocking.
+	for i := 1; i < 10; i++ {
+		tc.Delete(strconv.Itoa(i))
+	}
+	if n := tc.DroppedEvictions(); n != 7 {
+		t.Error("expected 7 dropped evictions, got", n)
+	}
+	close(release)
+	waitEvictions(mu, evicted, 3)
+	tc.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*evicted) != 3 {
+		t.Error("expected 3 callbacks, got", *evicted)
+	}
+}
+
+func TestAsyncEvictionsBlock(t *testing.T) {
+	f, started, release, evicted, mu := slowEvictions()
+	tc := New(100, DefaultExpiration, 0, WithOnEvicted(f), WithAsyncEvictions[string, int](2, BlockWhenFull))
+	for i := 0; i < 10; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+
+	tc.Delete("0")
+	<-started
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i < 10; i++ {
+			tc.Delete(strconv.Itoa(i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Delete did not block on a full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	waitEvictions(mu, evicted, 10)
+	tc.Close()
+	if n := tc.DroppedEvictions(); n != 0 {
+		t.Error("expected no dropped evictions, got", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*evicted) != 10 {
+		t.Error("expected 10 callbacks, got", *evicted)
+	}
+	for i, k := range *evicted {
+		if k != strconv.Itoa(i) {
+			t.Fatal("callbacks ran out of order:", *evicted)
+		}
+	}
+}
+
+func TestAsyncEvictionsAfterClose(t *testing.T) {
+	var n int64
+	tc := New(0, DefaultExpiration, 0, WithOnEvicted(func(string, int) {
+		atomic.AddInt64(&n, 1)
+	}), WithAsyncEvictions[string, int](16, BlockWhenFull))
+	for i := 0; i < 200; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	tc.Close()
+	for i := 0; i < 200; i++ {
+		tc.Delete(strconv.Itoa(i))
+	}
+	if got := atomic.LoadInt64(&n); got != 200 {
+		t.Errorf("got %d callbacks after Close, expected 200", got)
+	}
+}
@@ -0,0 +1,156 @@
+package simplecache
+
+import (
+	"context"
+	"time"
+)
+
+// Iterator is a pull iterator over a snapshot of a cache's items, taken when
+// the iterator is created. It holds no lock, so the cache can be used freely
+// while iterating and the caller may pause between items. The snapshot only
+// contains items that were unexpired when it was taken, but they may expire
+// or be deleted from the cache during the iteration.
+type Iterator[K comparable, V any] struct {
+	keys   []K
+	values []V
+	pos    int
+}
+
+// Iterator returns an Iterator over a snapshot of the unexpired items in the
+// cache.
+func (c *cache[K, V]) Iterator() *Iterator[K, V] {
+	it := &Iterator[K, V]{pos: -1}
+	c.RLock()
+	now := c.now()
+	for i := range c.items {
+		if !c.alive(&c.items[i], now) {
+			continue
+		}
+		it.keys = append(it.keys, c.items[i].key)
+		it.values = append(it.values, c.items[i].value)
+	}
+	c.RUnlock()
+	n := 0
+	for i, k := range it.keys {
+		if v, ok := c.load(k, it.values[i]); ok {
+			it.keys[n], it.values[n] = k, v
+			n++
+		}
+	}
+	it.keys, it.values = it.keys[:n], it.values[:n]
+	return it
+}
+
+// Next advances the iterator to the next item and reports whether there is
+// one.
+func (it *Iterator[K, V]) Next() bool {
+	if it.pos+1 >= len(it.keys) {
+		it.pos = len(it.keys)
+		return false
+	}
+	it.pos++
+	return true
+}
+
+// Key returns the key of the current item.
+func (it *Iterator[K, V]) Key() K {
+	return it.keys[it.pos]
+}
+
+// Value returns the value of the current item.
+func (it *Iterator[K, V]) Value() V {
+	return it.values[it.pos]
+}
+
+// Item is a cache item sent by Stream. Expiration is the zero Time for items
+// that never expire.
+type Item[K comparable, V any] struct {
+	Key        K
+	Value      V
+	Expiration time.Time
+}
+
+// streamBatch is the number of items Stream copies per read lock.
+const streamBatch = 256
+
+// Stream sends the unexpired items in the cache on the returned channel, which
+// is closed once every item has been sent or ctx is done. Unlike Iterator it
+// does not copy the whole cache up front: items are copied in small batches,
+// each under a brief read lock, so memory use stays bounded and writers are
+// not held up for long. Items may expire while they are being streamed.
+// Deleting items during the stream moves others around, so some items may
+// then be missed, and a key that is deleted and set again during the stream
+// may be sent twice, the second time with its new value.
+//
+// The caller must either drain the channel or cancel ctx, or the goroutine
+// feeding it will leak.
+func (c *cache[K, V]) Stream(ctx context.Context) <-chan Item[K, V] {
+	return stream(ctx, c, func(item *entry[K, V]) Item[K, V] {
+		it := Item[K, V]{Key: item.key, Value: item.value}
+		if item.Expiration > 0 {
+			it.Expiration = time.Unix(0, item.Expiration)
+		}
+		return it
+	}, func(it Item[K, V]) (Item[K, V], bool) {
+		var ok bool
+		it.Value, ok = c.load(it.Key, it.Value)
+		return it, ok
+	})
+}
+
+// KeysChan sends the keys of the unexpired items in the cache on the returned
+// channel, which is closed once every key has been sent or ctx is done. It is
+// to Keys what Stream is to Iterator: keys are copied in small batches, so a
+// huge cache is listed without allocating a slice of all its keys. The same
+// caveats apply: items may expire or be deleted while their keys are being
+// streamed, deleting items may make the stream miss others, a key that is
+// deleted and set again may be sent twice, and the caller must either drain
+// the channel or cancel ctx.
+func (c *cache[K, V]) KeysChan(ctx context.Context) <-chan K {
+	return stream(ctx, c, func(item *entry[K, V]) K {
+		return item.key
+	}, nil)
+}
+
+// stream feeds the channel returned by Stream and KeysChan, sending conv of
+// each unexpired item. conv is called under the read lock. If finish is not
+// nil, it is called on each converted item after the lock is released, and
+// items it returns false for are skipped.
+func stream[K comparable, V, T any](ctx context.Context, c *cache[K, V], conv func(*entry[K, V]) T, finish func(T) (T, bool)) <-chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		batch := make([]T, 0, streamBatch)
+		for pos, done := 0, false; !done; {
+			if ctx.Err() != nil {
+				return
+			}
+			batch = batch[:0]
+			c.RLock()
+			now := c.now()
+			for ; pos < len(c.items) && len(batch) < streamBatch; pos++ {
+				item := &c.items[pos]
+				if !c.alive(item, now) {
+					continue
+				}
+				batch = append(batch, conv(item))
+			}
+			done = pos >= len(c.items)
+			c.RUnlock()
+			for _, x := range batch {
+				if finish != nil {
+					var ok bool
+					if x, ok = finish(x); !ok {
+						continue
+					}
+				}
+				select {
+				case ch <- x:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,39 @@
+package simplecache
+
+// KV is a minimal map-like interface, for code that only needs to get, set
+// and delete values and should work with a plain map wrapper as well as a
+// cache. *Cache cannot implement it directly, since its Set takes an
+// expiration duration; use MapView to adapt it.
+type KV[K comparable, V any] interface {
+	Get(K) (V, bool)
+	Set(K, V)
+	Delete(K)
+}
+
+var _ KV[string, int] = MapView[string, int]{}
+
+// MapView adapts a cache to the KV interface. Values set through it use the
+// cache's default expiration.
+type MapView[K comparable, V any] struct {
+	c *cache[K, V]
+}
+
+// MapView returns a KV view of the cache.
+func (c *cache[K, V]) MapView() MapView[K, V] {
+	return MapView[K, V]{c}
+}
+
+// Get returns the item for k, like Cache.Get.
+func (m MapView[K, V]) Get(k K) (V, bool) {
+	return m.c.Get(k)
+}
+
+// Set sets the item for k with the cache's default expiration.
+func (m MapView[K, V]) Set(k K, v V) {
+	m.c.Set(k, v, DefaultExpiration)
+}
+
+// Delete deletes the item for k, like Cache.Delete.
+func (m MapView[K, V]) Delete(k K) {
+	m.c.Delete(k)
+}
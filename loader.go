@@ -0,0 +1,68 @@
+package simplecache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// loadCall is an in-flight or completed call to a loader function.
+type loadCall[V any] struct {
+	wg  sync.WaitGroup
+	val V
+	err error
+}
+
+// LoadOrStoreFn returns the item for k if it is in the cache. Otherwise it
+// calls fn, and stores the returned value with duration ttl only if fn
+// returned no error and cacheable is true. This keeps transient failures and
+// "not found" placeholders out of the cache.
+//
+// Concurrent callers that miss on the same key share a single call to fn and
+// all receive its result, including its error. If fn panics, the panic is
+// propagated to the caller that called fn and the others receive an error. A
+// result that was not stored is not served to later callers, which call fn
+// again.
+func (c *cache[K, V]) LoadOrStoreFn(k K, ttl time.Duration, fn func() (V, bool, error)) (V, error) {
+	if v, ok := c.Get(k); ok {
+		return v, nil
+	}
+
+	c.loadMu.Lock()
+	if call, ok := c.loads[k]; ok {
+		c.loadMu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err
+	}
+	if c.loads == nil {
+		c.loads = make(map[K]*loadCall[V])
+	}
+	call := &loadCall[V]{}
+	call.wg.Add(1)
+	c.loads[k] = call
+	c.loadMu.Unlock()
+
+	defer func() {
+		// If fn panicked, waiters get an error rather than a zero value
+		// and a nil error, and the panic carries on in this goroutine
+		r := recover()
+		if r != nil {
+			call.err = fmt.Errorf("simplecache: loading %v panicked: %v", k, r)
+		}
+		c.loadMu.Lock()
+		delete(c.loads, k)
+		c.loadMu.Unlock()
+		call.wg.Done()
+		if r != nil {
+			panic(r)
+		}
+	}()
+	v, cacheable, err := fn()
+	if err == nil && cacheable {
+		c.Set(k, v, ttl)
+	} else if err != nil {
+		c.logf("loading %v: %v", k, err)
+	}
+	call.val, call.err = v, err
+	return v, err
+}
@@ -0,0 +1,120 @@
+package simplecache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func loadConcurrently(tc *Cache[string, int], n int, fn func() (int, bool, error)) ([]int, []error) {
+	values := make([]int, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = tc.LoadOrStoreFn("a", DefaultExpiration, fn)
+		}(i)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+func TestLoadOrStoreFn(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	var calls int32
+	fn := func() (int, bool, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return 1, true, nil
+	}
+	values, errs := loadConcurrently(tc, 20, fn)
+	for i := range values {
+		if values[i] != 1 || errs[i] != nil {
+			t.Fatal("unexpected result:", values[i], errs[i])
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fn was called %d times, expected 1", calls)
+	}
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Error("loaded value was not stored")
+	}
+	if _, err := tc.LoadOrStoreFn("a", DefaultExpiration, fn); err != nil || calls != 1 {
+		t.Error("fn was called for a cached key")
+	}
+}
+
+func TestLoadOrStoreFnNotCached(t *testing.T) {
+	errLoad := errors.New("load failed")
+	for _, tt := range []struct {
+		name      string
+		cacheable bool
+		err       error
+	}{
+		{"error", true, errLoad},
+		{"not cacheable", false, nil},
+	} {
+		tc := New[string, int](100, DefaultExpiration, 0)
+		var calls int32
+		fn := func() (int, bool, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(10 * time.Millisecond)
+			return 7, tt.cacheable, tt.err
+		}
+		values, errs := loadConcurrently(tc, 20, fn)
+		for i := range values {
+			if values[i] != 7 || errs[i] != tt.err {
+				t.Fatalf("%s: unexpected result: %d, %v", tt.name, values[i], errs[i])
+			}
+		}
+		if calls != 1 {
+			t.Errorf("%s: fn was called %d times, expected 1", tt.name, calls)
+		}
+		if tc.Contains("a") {
+			t.Errorf("%s: result was stored", tt.name)
+		}
+		tc.LoadOrStoreFn("a", DefaultExpiration, fn)
+		if calls != 2 {
+			t.Errorf("%s: later caller was served the uncached result", tt.name)
+		}
+	}
+}
+
+func TestLoadOrStoreFnPanic(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	var calls int32
+	fn := func() (int, bool, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		panic("boom")
+	}
+	errs := make([]error, 20)
+	panicked := make([]bool, len(errs))
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				panicked[i] = recover() != nil
+			}()
+			_, errs[i] = tc.LoadOrStoreFn("a", DefaultExpiration, fn)
+		}(i)
+	}
+	wg.Wait()
+	panics := 0
+	for i, err := range errs {
+		if panicked[i] {
+			panics++
+		} else if err == nil {
+			t.Error("a waiter got a nil error from a panicking fn")
+		}
+	}
+	if calls != 1 || panics != 1 {
+		t.Errorf("fn was called %d times and panicked in %d callers, expected 1 and 1", calls, panics)
+	}
+}
@@ -0,0 +1,30 @@
+package simplecache
+
+// Logger is the interface the cache uses to report its activity: janitor
+// passes, items evicted to make room, and loader errors. *log.Logger
+// implements it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// WithLogger makes the cache log its activity to l. Messages are prefixed
+// with "simplecache: ", or with "simplecache[name]: " for a cache named with
+// WithName. By default nothing is logged. Errors that cannot be returned to a caller still go to the error
+// handler (see WithErrorHandler).
+func WithLogger[K comparable, V any](l Logger) Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.logger = l
+	}
+}
+
+// logf logs a message to the cache's logger, if it has one.
+func (c *cache[K, V]) logf(format string, args ...any) {
+	if c.logger == nil {
+		return
+	}
+	if c.name != "" {
+		c.logger.Printf("simplecache[%s]: "+format, append([]any{c.name}, args...)...)
+		return
+	}
+	c.logger.Printf("simplecache: "+format, args...)
+}
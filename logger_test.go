@@ -0,0 +1,57 @@
+package simplecache
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	tc := New(100, DefaultExpiration, 0,
+		WithLogger[string, int](log.New(&buf, "", 0)),
+		WithMaxItems[string, int](2))
+	clock := newFakeClock()
+	tc.now = clock.now
+
+	tc.Set("a", 1, time.Second)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	clock.Add(2 * time.Second)
+	tc.Set("d", 4, time.Second)
+	clock.Add(2 * time.Second)
+	tc.DeleteExpired()
+	tc.LoadOrStoreFn("e", DefaultExpiration, func() (int, bool, error) {
+		return 0, false, errors.New("backend down")
+	})
+
+	want := "simplecache: evicting a to make room\n" +
+		"simplecache: evicting b to make room\n" +
+		"simplecache: cleanup deleted 1 of 2 items\n" +
+		"simplecache: loading e: backend down\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected log output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLoggerName(t *testing.T) {
+	var buf bytes.Buffer
+	tc := New(100, DefaultExpiration, 0,
+		WithLogger[string, int](log.New(&buf, "", 0)),
+		WithName[string, int]("100%"),
+		WithMaxItems[string, int](1))
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.LoadOrStoreFn("c", DefaultExpiration, func() (int, bool, error) {
+		return 0, false, errors.New("backend down")
+	})
+
+	want := "simplecache[100%]: evicting a to make room\n" +
+		"simplecache[100%]: loading c: backend down\n"
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected log output:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,34 @@
+package simplecache
+
+// MoveEntry moves the item for k from one cache to another, keeping its
+// expiration time, capped by to's WithMaxTTL, for promoting and demoting items between tiers. It reports
+// whether the item was moved: it returns false, and leaves both caches as
+// they were, if k is missing or expired in from, or if to refuses the write
+// as by Set. Any item for k in to is replaced. Moving an item does not call
+// from's onEvicted callback. Both caches are locked for the move, in a
+// consistent order so that concurrent moves in opposite directions cannot
+// deadlock.
+func MoveEntry[K comparable, V any](from, to *Cache[K, V], k K) bool {
+	f, t := from.cache, to.cache
+	if f == t {
+		return f.Contains(k)
+	}
+	if f.id < t.id {
+		f.Lock()
+		t.Lock()
+	} else {
+		t.Lock()
+		f.Lock()
+	}
+	moved := false
+	if idx, found := f.lookup(k, f.now()); found {
+		item := &f.items[idx]
+		if v, ok := f.load(k, item.value); ok && t.setExpiration(k, v, t.capExpiration(item.Expiration)) == nil {
+			f.delete(k)
+			moved = true
+		}
+	}
+	f.Unlock()
+	t.unlock()
+	return moved
+}
@@ -0,0 +1,77 @@
+package simplecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMoveEntry(t *testing.T) {
+	clock := newFakeClock()
+	from := New[string, int](10, NoExpiration, 0)
+	to := New[string, int](10, NoExpiration, 0)
+	from.now, to.now = clock.now, clock.now
+
+	from.Set("a", 1, time.Minute)
+	_, exp, _ := from.GetWithExpiration("a")
+	clock.Add(10 * time.Second)
+	if !MoveEntry(from, to, "a") {
+		t.Fatal("MoveEntry did not move an existing item")
+	}
+	if _, ok := from.Get("a"); ok {
+		t.Error("item is still in the source cache")
+	}
+	v, movedExp, ok := to.GetWithExpiration("a")
+	if !ok || v != 1 {
+		t.Errorf("got %d, %v in the target cache, expected 1, true", v, ok)
+	}
+	if !movedExp.Equal(exp) {
+		t.Errorf("expiration changed from %v to %v", exp, movedExp)
+	}
+
+	if MoveEntry(from, to, "missing") {
+		t.Error("MoveEntry moved a missing item")
+	}
+	from.Set("b", 2, time.Second)
+	clock.Add(time.Minute)
+	if MoveEntry(from, to, "b") {
+		t.Error("MoveEntry moved an expired item")
+	}
+	if _, ok := to.Get("b"); ok {
+		t.Error("expired item was added to the target cache")
+	}
+
+	// Moving back and forth concurrently must not deadlock.
+	from.Set("c", 3, NoExpiration)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			MoveEntry(from, to, "c")
+		}
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		MoveEntry(to, from, "c")
+	}
+	<-done
+	_, inFrom := from.Get("c")
+	_, inTo := to.Get("c")
+	if inFrom == inTo {
+		t.Errorf("item in source: %v, in target: %v, expected exactly one", inFrom, inTo)
+	}
+}
+
+func TestMoveEntryMaxTTL(t *testing.T) {
+	clock := newFakeClock()
+	from := New[string, int](10, NoExpiration, 0)
+	to := New(10, NoExpiration, 0, WithMaxTTL[string, int](time.Minute))
+	from.now, to.now = clock.now, clock.now
+
+	from.Set("a", 1, NoExpiration)
+	if !MoveEntry(from, to, "a") {
+		t.Fatal("MoveEntry did not move an existing item")
+	}
+	want := time.Unix(0, clock.now()).Add(time.Minute)
+	if _, exp, ok := to.GetWithExpiration("a"); !ok || !exp.Equal(want) {
+		t.Errorf("moved item expires at %v, %v, expected it capped at %v", exp, ok, want)
+	}
+}
@@ -0,0 +1,125 @@
+package simplecache
+
+import "time"
+
+// Multimap is a cache in which a key maps to several values, each with its
+// own expiration time. It is built on a Cache holding each key's values;
+// expired values are pruned whenever their key is accessed, and the janitor
+// deletes keys whose values have all expired.
+type Multimap[K comparable, V any] struct {
+	c *Cache[K, []multiValue[V]]
+}
+
+type multiValue[V any] struct {
+	value      V
+	expiration int64
+}
+
+// NewMultimap returns a new Multimap. defaultExpiration and cleanupInterval
+// are interpreted as in New, applying to each value.
+func NewMultimap[K comparable, V any](defaultExpiration, cleanupInterval time.Duration) *Multimap[K, V] {
+	return &Multimap[K, V]{c: New[K, []multiValue[V]](0, defaultExpiration, cleanupInterval)}
+}
+
+// Add appends v to the values of k. The duration is interpreted as in
+// Cache.Set and applies to v alone.
+func (m *Multimap[K, V]) Add(k K, v V, d time.Duration) {
+	e := m.c.expiration(d)
+	m.c.Lock()
+	vs := append(m.prune(k), multiValue[V]{value: v, expiration: e})
+	m.c.setExpiration(k, vs, lastExpiration(vs))
+	m.c.unlock()
+}
+
+// GetAll returns the unexpired values of k, in the order they were added.
+func (m *Multimap[K, V]) GetAll(k K) []V {
+	var out []V
+	m.c.Lock()
+	// Copy under the lock: the stored slice is shared with writers
+	if vs := m.prune(k); len(vs) > 0 {
+		out = make([]V, len(vs))
+		for i := range vs {
+			out[i] = vs[i].value
+		}
+	}
+	m.c.Unlock()
+	return out
+}
+
+// RemoveValue removes every value of k for which equal(value, v) returns
+// true, and returns the number of values removed.
+func (m *Multimap[K, V]) RemoveValue(k K, v V, equal func(a, b V) bool) int {
+	m.c.Lock()
+	vs := m.prune(k)
+	kept := make([]multiValue[V], 0, len(vs))
+	for _, mv := range vs {
+		if !equal(mv.value, v) {
+			kept = append(kept, mv)
+		}
+	}
+	n := len(vs) - len(kept)
+	if n > 0 {
+		m.store(k, kept)
+	}
+	m.c.Unlock()
+	return n
+}
+
+// Delete removes all values of k.
+func (m *Multimap[K, V]) Delete(k K) {
+	m.c.Delete(k)
+}
+
+// Close stops the janitor, like Cache.Close.
+func (m *Multimap[K, V]) Close() {
+	m.c.Close()
+}
+
+// prune drops the expired values of k and returns the remaining ones. The
+// caller must hold the write lock.
+func (m *Multimap[K, V]) prune(k K) []multiValue[V] {
+	idx, found := m.c.index(k)
+	if !found {
+		return nil
+	}
+	vs := m.c.items[idx].value
+	now := m.c.now()
+	// Build a new slice rather than compacting the stored one in place
+	live := make([]multiValue[V], 0, len(vs))
+	for _, mv := range vs {
+		if mv.expiration == 0 || now <= mv.expiration {
+			live = append(live, mv)
+		}
+	}
+	if len(live) != len(vs) {
+		m.store(k, live)
+	}
+	return live
+}
+
+// store replaces the values of k, deleting k if there are none left. The
+// caller must hold the write lock.
+func (m *Multimap[K, V]) store(k K, vs []multiValue[V]) {
+	if len(vs) == 0 {
+		m.c.delete(k)
+		return
+	}
+	idx, _ := m.c.index(k)
+	m.c.items[idx].value = vs
+	m.c.items[idx].Expiration = lastExpiration(vs)
+}
+
+// lastExpiration returns the expiration time of the value that expires last,
+// or 0 if one of them never expires.
+func lastExpiration[V any](vs []multiValue[V]) int64 {
+	var last int64
+	for _, mv := range vs {
+		if mv.expiration == 0 {
+			return 0
+		}
+		if mv.expiration > last {
+			last = mv.expiration
+		}
+	}
+	return last
+}
@@ -0,0 +1,103 @@
+package simplecache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultimap(t *testing.T) {
+	m := NewMultimap[string, int](time.Minute, 0)
+	clock := newFakeClock()
+	m.c.now = clock.now
+
+	m.Add("a", 1, DefaultExpiration)
+	m.Add("a", 2, time.Second)
+	m.Add("a", 3, NoExpiration)
+	m.Add("b", 4, time.Second)
+	if vs := m.GetAll("a"); len(vs) != 3 || vs[0] != 1 || vs[1] != 2 || vs[2] != 3 {
+		t.Error("unexpected values for a:", vs)
+	}
+
+	// Each value expires on its own
+	clock.Add(2 * time.Second)
+	if vs := m.GetAll("a"); len(vs) != 2 || vs[0] != 1 || vs[1] != 3 {
+		t.Error("unexpected values for a after 2s:", vs)
+	}
+	if vs := m.GetAll("b"); vs != nil {
+		t.Error("unexpected values for b after 2s:", vs)
+	}
+	if m.c.Len() != 1 {
+		t.Error("key b was not deleted once its values expired")
+	}
+
+	equal := func(a, b int) bool { return a == b }
+	if n := m.RemoveValue("a", 3, equal); n != 1 {
+		t.Error("expected 1 value to be removed, got", n)
+	}
+	if n := m.RemoveValue("a", 3, equal); n != 0 {
+		t.Error("expected no value to be removed, got", n)
+	}
+	if vs := m.GetAll("a"); len(vs) != 1 || vs[0] != 1 {
+		t.Error("unexpected values for a after RemoveValue:", vs)
+	}
+	m.RemoveValue("a", 1, equal)
+	if m.c.Len() != 0 {
+		t.Error("key a was not deleted once its last value was removed")
+	}
+}
+
+func TestMultimapJanitor(t *testing.T) {
+	m := NewMultimap[string, int](DefaultExpiration, 0)
+	clock := newFakeClock()
+	m.c.now = clock.now
+	m.Add("a", 1, time.Second)
+	m.Add("a", 2, time.Minute)
+	m.Add("b", 3, time.Second)
+	clock.Add(2 * time.Second)
+
+	// DeleteExpired is what the janitor runs
+	m.c.DeleteExpired()
+	if m.c.Contains("b") {
+		t.Error("key with only expired values was not deleted")
+	}
+	if vs := m.GetAll("a"); len(vs) != 1 || vs[0] != 2 {
+		t.Error("unexpected values for a:", vs)
+	}
+	clock.Add(time.Minute)
+	m.c.DeleteExpired()
+	if m.c.Len() != 0 {
+		t.Error("expected an empty multimap, got", m.c.Keys())
+	}
+}
+
+func TestMultimapConcurrent(t *testing.T) {
+	m := NewMultimap[string, int](NoExpiration, 0)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				m.Add("a", i, DefaultExpiration)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				m.RemoveValue("a", i, func(a, b int) bool { return a == b })
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				for _, v := range m.GetAll("a") {
+					if v < 0 || v >= 500 {
+						t.Errorf("unexpected value %d", v)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,105 @@
+package simplecache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// A snapshot written by Save starts with snapshotMagic followed by a single
+// format-version byte, then the gob-encoded items. Bump snapshotVersion
+// whenever the encoding of savedItem changes.
+const (
+	snapshotMagic   = "SCGO"
+	snapshotVersion = 1
+)
+
+// savedItem is the persisted form of an item. Its fields are exported for gob.
+type savedItem[K comparable, V any] struct {
+	Key        K
+	Value      V
+	Expiration int64
+}
+
+// Save writes the cache's unexpired items to w. Keys and values must be
+// encodable with encoding/gob; values of interface type need their concrete
+// types registered with gob.Register. Expiration times are saved as absolute
+// times, so items keep expiring while the snapshot is on disk.
+func (c *cache[K, V]) Save(w io.Writer) error {
+	return c.SaveFunc(w, nil)
+}
+
+// SaveFunc is like Save, but only writes the unexpired items for which pred
+// returns true. pred is called with the cache's read lock held. A nil pred
+// matches every item.
+func (c *cache[K, V]) SaveFunc(w io.Writer, pred func(k K, v V) bool) (err error) {
+	c.RLock()
+	now := c.now()
+	items := make([]savedItem[K, V], 0, len(c.items))
+	for i := range c.items {
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			continue
+		}
+		v, ok := c.load(item.key, item.value)
+		if !ok {
+			continue
+		}
+		if pred != nil && !pred(item.key, v) {
+			continue
+		}
+		items = append(items, savedItem[K, V]{Key: item.key, Value: v, Expiration: item.Expiration})
+	}
+	c.RUnlock()
+
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("simplecache: error registering item types with gob library: %v", x)
+		}
+	}()
+	header := append([]byte(snapshotMagic), snapshotVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(items)
+}
+
+// Load adds the items in a snapshot written by Save to the cache, keeping
+// their original expiration times, capped by WithMaxTTL. Items that have
+// expired since they were saved are skipped, and existing unexpired items are
+// not overwritten. Load fails without touching the cache if r does not hold a
+// snapshot in the current format. Items the cache refuses, as Set would, are
+// skipped too, and the error for the first of them is returned once the rest
+// are loaded.
+func (c *cache[K, V]) Load(r io.Reader) (err error) {
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("simplecache: reading snapshot header: %w", err)
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("simplecache: not a cache snapshot (bad magic %q)", header[:len(snapshotMagic)])
+	}
+	if v := header[len(snapshotMagic)]; v != snapshotVersion {
+		return fmt.Errorf("simplecache: unsupported snapshot version %d (want %d)", v, snapshotVersion)
+	}
+	var items []savedItem[K, V]
+	if err := gob.NewDecoder(r).Decode(&items); err != nil {
+		return fmt.Errorf("simplecache: decoding snapshot: %w", err)
+	}
+
+	c.Lock()
+	now := c.now()
+	for _, item := range items {
+		if item.Expiration > 0 && now > item.Expiration {
+			continue
+		}
+		if _, found := c.get(item.Key); found {
+			continue
+		}
+		if serr := c.setExpiration(item.Key, item.Value, c.capExpiration(item.Expiration)); serr != nil && err == nil {
+			err = fmt.Errorf("simplecache: loading %v: %w", item.Key, serr)
+		}
+	}
+	c.unlock()
+	return err
+}
@@ -0,0 +1,137 @@
+package simplecache
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	tc.Set("a", 1, NoExpiration)
+	tc.Set("b", 2, time.Minute)
+	tc.Set("gone", 3, time.Nanosecond)
+	clock.Add(time.Second)
+
+	var buf bytes.Buffer
+	if err := tc.Save(&buf); err != nil {
+		t.Fatal("Save:", err)
+	}
+
+	tc2 := New[string, int](100, DefaultExpiration, 0)
+	tc2.now = clock.now
+	tc2.Set("a", 10, NoExpiration)
+	if err := tc2.Load(&buf); err != nil {
+		t.Fatal("Load:", err)
+	}
+	if x, _ := tc2.Get("a"); x != 10 {
+		t.Error("Load overwrote an existing item: a is", x)
+	}
+	if x, found := tc2.Get("b"); !found || x != 2 {
+		t.Error("b was not loaded:", x, found)
+	}
+	if tc2.Contains("gone") {
+		t.Error("expired item was saved")
+	}
+	clock.Add(time.Minute)
+	if tc2.Contains("b") {
+		t.Error("b did not keep its expiration time")
+	}
+}
+
+func TestLoadLimits(t *testing.T) {
+	clock := newFakeClock()
+	tc := New[string, int](100, DefaultExpiration, 0)
+	tc.now = clock.now
+	tc.Set("a", 1, time.Hour)
+	tc.Set("b", 2, NoExpiration)
+	tc.Set("c", 3, time.Hour)
+	var buf bytes.Buffer
+	if err := tc.Save(&buf); err != nil {
+		t.Fatal("Save:", err)
+	}
+
+	tc2 := New(100, DefaultExpiration, 0, WithMaxTTL[string, int](time.Minute),
+		WithMaxItems[string, int](2), WithOverflowPolicy[string, int](RejectNew))
+	tc2.now = clock.now
+	if err := tc2.Load(&buf); !errors.Is(err, ErrCapacity) {
+		t.Error("expected ErrCapacity from Load, got", err)
+	}
+	if n := tc2.Len(); n != 2 {
+		t.Error("expected the items that fit to be loaded, got", n)
+	}
+	now := time.Unix(0, clock.now())
+	for _, k := range tc2.Keys() {
+		if _, exp, _ := tc2.GetWithExpiration(k); !exp.Equal(now.Add(time.Minute)) {
+			t.Errorf("%s expires at %v, expected it capped at %v", k, exp, now.Add(time.Minute))
+		}
+	}
+}
+
+func TestLoadBadHeader(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	tc.Set("a", 1, DefaultExpiration)
+	var buf bytes.Buffer
+	if err := tc.Save(&buf); err != nil {
+		t.Fatal("Save:", err)
+	}
+	good := buf.Bytes()
+
+	badMagic := append([]byte("JUNK"), good[len(snapshotMagic):]...)
+	badVersion := append([]byte(nil), good...)
+	badVersion[len(snapshotMagic)] = snapshotVersion + 1
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"magic", badMagic, "bad magic"},
+		{"version", badVersion, "unsupported snapshot version"},
+		{"short", good[:2], "reading snapshot header"},
+	} {
+		tc2 := New[string, int](100, DefaultExpiration, 0)
+		err := tc2.Load(bytes.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.want)
+		}
+		if tc2.Len() != 0 {
+			t.Errorf("%s: Load added items from a rejected snapshot", tt.name)
+		}
+	}
+}
+
+func TestSaveFunc(t *testing.T) {
+	tc := New[int, int](100, DefaultExpiration, 0)
+	for i := 0; i < 10; i++ {
+		tc.Set(i, i*10, DefaultExpiration)
+	}
+	var buf bytes.Buffer
+	err := tc.SaveFunc(&buf, func(k, v int) bool {
+		return k%2 == 0
+	})
+	if err != nil {
+		t.Fatal("SaveFunc:", err)
+	}
+
+	tc2 := New[int, int](100, DefaultExpiration, 0)
+	if err := tc2.Load(&buf); err != nil {
+		t.Fatal("Load:", err)
+	}
+	if n := tc2.Len(); n != 5 {
+		t.Errorf("expected 5 items to be loaded, got %d", n)
+	}
+	for i := 0; i < 10; i++ {
+		x, found := tc2.Get(i)
+		if found != (i%2 == 0) {
+			t.Errorf("item %d: found is %v", i, found)
+		}
+		if found && x != i*10 {
+			t.Errorf("item %d: got %d", i, x)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package simplecache
+
+import "strings"
+
+// KeysWithPrefix returns the keys of the unexpired items in a string-keyed
+// cache that start with prefix. It scans every item, so on large caches it
+// costs as much as Keys; a prefix index would be needed to make it faster.
+//
+// KeysWithPrefix and DeleteWithPrefix are functions rather than methods
+// because they only apply to caches with string keys.
+func KeysWithPrefix[V any](c *Cache[string, V], prefix string) []string {
+	var ks []string
+	c.RLock()
+	now := c.now()
+	for i := range c.items {
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			continue
+		}
+		if strings.HasPrefix(item.key, prefix) {
+			ks = append(ks, item.key)
+		}
+	}
+	c.RUnlock()
+	return ks
+}
+
+// DeleteWithPrefix deletes every item in a string-keyed cache whose key
+// starts with prefix and returns the number of unexpired items deleted. The
+// onEvicted callback is called for all deleted items. Like KeysWithPrefix, it
+// scans every item.
+func DeleteWithPrefix[V any](c *Cache[string, V], prefix string) int {
+	var ks []string
+	var vs []V
+	n := 0
+	c.Lock()
+	now := c.now()
+	for i := range c.items {
+		item := &c.items[i]
+		if !strings.HasPrefix(item.key, prefix) {
+			continue
+		}
+		if c.alive(item, now) {
+			n++
+		}
+		ks = append(ks, item.key)
+	}
+	for _, k := range ks {
+		if v, evicted := c.delete(k); evicted {
+			vs = append(vs, v)
+		}
+	}
+	c.Unlock()
+	for i := range vs {
+		c.notifyEvicted(c.onEvicted, ks[i], vs[i])
+	}
+	return n
+}
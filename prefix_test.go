@@ -0,0 +1,61 @@
+package simplecache
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeysWithPrefix(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	for _, k := range []string{"tenant:1:a", "tenant:1:b", "tenant:12:a", "tenant:2:a", "other"} {
+		tc.Set(k, 0, DefaultExpiration)
+	}
+	tc.Set("tenant:1:old", 0, time.Second)
+	clock.Add(2 * time.Second)
+
+	for _, tt := range []struct {
+		prefix string
+		want   string
+	}{
+		{"tenant:1:", "tenant:1:a tenant:1:b"},
+		{"tenant:1", "tenant:12:a tenant:1:a tenant:1:b"},
+		{"tenant:", "tenant:12:a tenant:1:a tenant:1:b tenant:2:a"},
+		{"nope", ""},
+	} {
+		ks := KeysWithPrefix(tc, tt.prefix)
+		sort.Strings(ks)
+		if got := strings.Join(ks, " "); got != tt.want {
+			t.Errorf("KeysWithPrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestDeleteWithPrefix(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	var evicted []string
+	tc.OnEvicted(func(k string, v int) {
+		evicted = append(evicted, k)
+	})
+	for _, k := range []string{"tenant:1:a", "tenant:1:b", "tenant:12:a", "other"} {
+		tc.Set(k, 0, DefaultExpiration)
+	}
+	tc.Set("tenant:1:old", 0, time.Second)
+	clock.Add(2 * time.Second)
+
+	if n := DeleteWithPrefix(tc, "tenant:1:"); n != 2 {
+		t.Error("expected 2 unexpired items to be deleted, got", n)
+	}
+	if len(evicted) != 3 {
+		t.Error("expected 3 evictions, got", evicted)
+	}
+	ks := tc.SortedKeys(func(a, b string) bool { return a < b })
+	if strings.Join(ks, " ") != "other tenant:12:a" {
+		t.Error("unexpected keys left:", ks)
+	}
+}
@@ -0,0 +1,30 @@
+package simplecache
+
+import "time"
+
+// Allow implements a fixed-window rate limiter on a cache of counters. It
+// counts a request for k and reports whether it is within limit: the first
+// request starts a window of length window, and once limit requests have
+// been allowed in it, further ones are refused until the window expires.
+// The check and the increment happen under one write lock, so concurrent
+// callers never get more than limit requests through per window.
+//
+// Allow is a function rather than a method because it only applies to caches
+// of int counters.
+func Allow[K comparable](c *Cache[K, int], k K, limit int, window time.Duration) bool {
+	c.Lock()
+	n, found := c.get(k)
+	if !found {
+		ok := limit > 0 && c.set(k, 1, window) == nil
+		c.unlock()
+		return ok
+	}
+	if n >= limit {
+		c.Unlock()
+		return false
+	}
+	idx, _ := c.index(k)
+	ok := c.setExpiration(k, n+1, c.items[idx].Expiration) == nil
+	c.unlock()
+	return ok
+}
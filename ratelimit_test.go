@@ -0,0 +1,51 @@
+package simplecache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	tc.now = clock.now
+	for i := 0; i < 3; i++ {
+		if !Allow(tc, "ip", 3, time.Second) {
+			t.Fatal("request", i, "was refused")
+		}
+	}
+	if Allow(tc, "ip", 3, time.Second) {
+		t.Error("request over the limit was allowed")
+	}
+	if !Allow(tc, "other", 3, time.Second) {
+		t.Error("limit is not per key")
+	}
+
+	clock.Add(2 * time.Second)
+	if !Allow(tc, "ip", 3, time.Second) {
+		t.Error("request in a new window was refused")
+	}
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	tc := New[string, int](100, DefaultExpiration, 0)
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if Allow(tc, "k", 100, time.Hour) {
+					atomic.AddInt32(&allowed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 100 {
+		t.Error("expected exactly 100 requests to be allowed, got", allowed)
+	}
+}
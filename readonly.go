@@ -0,0 +1,47 @@
+package simplecache
+
+import "time"
+
+// ReadOnlyCache is a view of a cache that can only read it, for handing the
+// cache to code that must not modify it. It reads the same underlying cache,
+// so it sees every write made through the cache itself.
+type ReadOnlyCache[K comparable, V any] struct {
+	c *cache[K, V]
+}
+
+// ReadOnly returns a read-only view of the cache.
+func (c *cache[K, V]) ReadOnly() ReadOnlyCache[K, V] {
+	return ReadOnlyCache[K, V]{c}
+}
+
+// Get an item from the cache, like Cache.Get.
+func (r ReadOnlyCache[K, V]) Get(k K) (V, bool) {
+	return r.c.Get(k)
+}
+
+// GetWithExpiration gets an item and its expiration time, like
+// Cache.GetWithExpiration.
+func (r ReadOnlyCache[K, V]) GetWithExpiration(k K) (V, time.Time, bool) {
+	return r.c.GetWithExpiration(k)
+}
+
+// Contains reports whether an unexpired item exists for k, like
+// Cache.Contains.
+func (r ReadOnlyCache[K, V]) Contains(k K) bool {
+	return r.c.Contains(k)
+}
+
+// Keys returns the keys of all unexpired items, like Cache.Keys.
+func (r ReadOnlyCache[K, V]) Keys() []K {
+	return r.c.Keys()
+}
+
+// Len returns the number of items, like Cache.Len.
+func (r ReadOnlyCache[K, V]) Len() int {
+	return r.c.Len()
+}
+
+// Range calls fn for each unexpired item, like Cache.Range.
+func (r ReadOnlyCache[K, V]) Range(fn func(k K, v V) bool) {
+	r.c.Range(fn)
+}
@@ -0,0 +1,80 @@
+package simplecache
+
+import (
+	"container/heap"
+	"sync/atomic"
+)
+
+type keyAccess[K comparable] struct {
+	key    K
+	access int64
+}
+
+// accessHeap is a heap of keys ordered by access time: oldest on top when
+// hot is true, newest on top otherwise. It keeps the n hottest (or coldest)
+// keys seen so far by evicting its top whenever it grows past n.
+type accessHeap[K comparable] struct {
+	items []keyAccess[K]
+	hot   bool
+}
+
+func (h *accessHeap[K]) Len() int { return len(h.items) }
+
+func (h *accessHeap[K]) Less(i, j int) bool {
+	if h.hot {
+		return h.items[i].access < h.items[j].access
+	}
+	return h.items[i].access > h.items[j].access
+}
+
+func (h *accessHeap[K]) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *accessHeap[K]) Push(x any) { h.items = append(h.items, x.(keyAccess[K])) }
+
+func (h *accessHeap[K]) Pop() any {
+	n := len(h.items) - 1
+	x := h.items[n]
+	h.items = h.items[:n]
+	return x
+}
+
+// Hottest returns up to n unexpired keys that were read most recently, most
+// recent first. Reads are only tracked with WithAccessTracking; without it
+// the keys are ordered by the time they were set.
+func (c *cache[K, V]) Hottest(n int) []K {
+	return c.byAccess(n, true)
+}
+
+// Coldest returns up to n unexpired keys that were read least recently, least
+// recent first. See Hottest.
+func (c *cache[K, V]) Coldest(n int) []K {
+	return c.byAccess(n, false)
+}
+
+// byAccess selects the n hottest or coldest keys with a bounded heap, which
+// costs O(len * log n) rather than sorting every key.
+func (c *cache[K, V]) byAccess(n int, hot bool) []K {
+	if n <= 0 {
+		return nil
+	}
+	h := &accessHeap[K]{items: make([]keyAccess[K], 0, n+1), hot: hot}
+	c.RLock()
+	now := c.now()
+	for i := range c.items {
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			continue
+		}
+		heap.Push(h, keyAccess[K]{key: item.key, access: atomic.LoadInt64(&item.lastAccess)})
+		if h.Len() > n {
+			heap.Pop(h)
+		}
+	}
+	c.RUnlock()
+
+	ks := make([]K, h.Len())
+	for i := len(ks) - 1; i >= 0; i-- {
+		ks[i] = heap.Pop(h).(keyAccess[K]).key
+	}
+	return ks
+}
@@ -0,0 +1,66 @@
+package simplecache
+
+import (
+	"sort"
+	"time"
+)
+
+// ringReplicas is the number of points each shard owns on a hash ring. More
+// points spread keys more evenly across shards.
+const ringReplicas = 160
+
+type ringPoint struct {
+	hash  uint32
+	shard int
+}
+
+// hashRing places keys on shards with consistent hashing: a key belongs to the
+// shard owning the first point at or after the key's hash. Adding or removing
+// a shard only moves the keys next to that shard's points, instead of
+// remapping almost every key like hash % shards does.
+type hashRing []ringPoint
+
+func newHashRing(seed uint32, shards int) hashRing {
+	r := make(hashRing, 0, shards*ringReplicas)
+	for s := 0; s < shards; s++ {
+		for i := 0; i < ringReplicas; i++ {
+			h := mix32(seed + uint32(s*ringReplicas+i))
+			r = append(r, ringPoint{hash: h, shard: s})
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].hash < r[j].hash })
+	return r
+}
+
+func (r hashRing) shard(h uint32) int {
+	i := sort.Search(len(r), func(i int) bool { return r[i].hash >= h })
+	if i == len(r) {
+		i = 0
+	}
+	return r[i].shard
+}
+
+// mix32 is the murmur3 finalizer. djb33 hashes of similar keys are close to
+// each other; mixing spreads them over the whole ring.
+func mix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+func withConsistentHashing() ShardedOption {
+	return func(cfg *shardedConfig) {
+		cfg.consistent = true
+	}
+}
+
+// NewConsistentSharded returns a sharded cache like NewSharded, but keys are
+// placed on shards with a consistent hash ring instead of hash % shards, so
+// that a different shard count only remaps a fraction of the keys.
+func NewConsistentSharded[V any](defaultExpiration, cleanupInterval time.Duration, shards int, opts ...ShardedOption) *ShardedCache[V] {
+	opts = append(opts[:len(opts):len(opts)], withConsistentHashing())
+	return NewSharded[V](defaultExpiration, cleanupInterval, shards, opts...)
+}
@@ -0,0 +1,53 @@
+package simplecache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func remapFraction(a, b *ShardedCache[int], keys []string) float64 {
+	moved := 0
+	for _, k := range keys {
+		if a.shardIndex(k) != b.shardIndex(k) {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(keys))
+}
+
+func TestConsistentShardedCache(t *testing.T) {
+	tc := NewConsistentSharded[int](DefaultExpiration, 0, 8)
+	for i := 0; i < 10000; i++ {
+		tc.Set("user:"+strconv.Itoa(i)+":profile", i, DefaultExpiration)
+	}
+	for i := 0; i < 10000; i++ {
+		if v, ok := tc.Get("user:" + strconv.Itoa(i) + ":profile"); !ok || v != i {
+			t.Fatalf("%d was not found", i)
+		}
+	}
+	if n := tc.Imbalance(); n > 1.5 {
+		t.Error("keys are spread unevenly across the ring:", n)
+	}
+}
+
+func TestConsistentShardedCacheRemap(t *testing.T) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "user:" + strconv.Itoa(i)
+	}
+	ring8 := NewConsistentSharded[int](DefaultExpiration, 0, 8, WithSeed(1))
+	ring9 := NewConsistentSharded[int](DefaultExpiration, 0, 9, WithSeed(1))
+	mod8 := NewSharded[int](DefaultExpiration, 0, 8, WithSeed(1))
+	mod9 := NewSharded[int](DefaultExpiration, 0, 9, WithSeed(1))
+
+	// Ideally only the keys taken over by the new shard move: 1/9 of them
+	ringMoved := remapFraction(ring8, ring9, keys)
+	modMoved := remapFraction(mod8, mod9, keys)
+	t.Logf("remapped keys going from 8 to 9 shards: ring %.3f, modulo %.3f", ringMoved, modMoved)
+	if ringMoved > 0.2 {
+		t.Errorf("consistent hashing remapped %.3f of the keys", ringMoved)
+	}
+	if ringMoved >= modMoved {
+		t.Error("consistent hashing remapped no fewer keys than modulo hashing")
+	}
+}
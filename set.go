@@ -0,0 +1,50 @@
+package simplecache
+
+import "time"
+
+// Set is a set of keys with expiration times, for membership checks such as
+// deduplication windows. It is a Cache with empty values, so members cost no
+// more than their key and index entry.
+type Set[K comparable] struct {
+	c *Cache[K, struct{}]
+}
+
+// NewSet returns a new Set. defaultExpiration and cleanupInterval are
+// interpreted as in New.
+func NewSet[K comparable](defaultExpiration, cleanupInterval time.Duration) *Set[K] {
+	return &Set[K]{c: New[K, struct{}](0, defaultExpiration, cleanupInterval)}
+}
+
+// Add adds k to the set, or resets its expiration if it is already a member,
+// and reports whether k was added, not having been a member before. It
+// returns false without adding k if the set is closed. The duration is
+// interpreted as in Cache.Set.
+func (s *Set[K]) Add(k K, d time.Duration) bool {
+	e := s.c.expiration(d)
+	s.c.Lock()
+	_, found := s.c.get(k)
+	err := s.c.setExpiration(k, struct{}{}, e)
+	s.c.unlock()
+	return err == nil && !found
+}
+
+// Contains reports whether k is an unexpired member of the set.
+func (s *Set[K]) Contains(k K) bool {
+	return s.c.Contains(k)
+}
+
+// Remove removes k from the set.
+func (s *Set[K]) Remove(k K) {
+	s.c.Delete(k)
+}
+
+// Len returns the number of members, including expired members that have not
+// been cleaned up yet.
+func (s *Set[K]) Len() int {
+	return s.c.Len()
+}
+
+// Close stops the janitor, like Cache.Close.
+func (s *Set[K]) Close() {
+	s.c.Close()
+}
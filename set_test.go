@@ -0,0 +1,55 @@
+package simplecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSet(t *testing.T) {
+	s := NewSet[string](time.Minute, 0)
+	clock := newFakeClock()
+	s.c.now = clock.now
+
+	if !s.Add("a", DefaultExpiration) {
+		t.Error("a was already a member")
+	}
+	if s.Add("a", DefaultExpiration) {
+		t.Error("a was not a member")
+	}
+	s.Add("b", time.Second)
+	s.Add("c", NoExpiration)
+	if !s.Contains("a") || !s.Contains("b") || !s.Contains("c") || s.Contains("d") {
+		t.Error("unexpected membership")
+	}
+
+	clock.Add(2 * time.Second)
+	if s.Contains("b") {
+		t.Error("b did not expire")
+	}
+	if !s.Add("b", time.Second) {
+		t.Error("expired b was still a member")
+	}
+
+	clock.Add(2 * time.Minute)
+	if s.Contains("a") || !s.Contains("c") {
+		t.Error("a did not expire with the default expiration")
+	}
+	s.Remove("c")
+	if s.Contains("c") {
+		t.Error("c was not removed")
+	}
+	if s.Len() != 2 {
+		t.Error("expected 2 uncleaned members, got", s.Len())
+	}
+}
+
+func TestSetClosed(t *testing.T) {
+	s := NewSet[string](time.Minute, 0)
+	s.Close()
+	if s.Add("a", DefaultExpiration) {
+		t.Error("Add reported adding a member to a closed set")
+	}
+	if s.Contains("a") {
+		t.Error("a member was added to a closed set")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	insecurerand "math/rand"
 	"os"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,10 +26,47 @@ type ShardedCache[V any] struct {
 }
 
 type shardedCache[V any] struct {
-	seed uint32
-	m    uint32
-	cs   []*cache[string, V]
-	stop chan struct{}
+	seed      uint32
+	m         uint32
+	cs        []*cache[string, V]
+	stop      chan struct{}
+	closeOnce sync.Once
+	shardKey  func(string) string
+	hash      HashFunc
+	ring      hashRing // nil unless created by NewConsistentSharded
+}
+
+// ShardedOption configures optional behaviour of a sharded cache created by
+// NewSharded.
+type ShardedOption func(*shardedConfig)
+
+type shardedConfig struct {
+	shardKey   func(string) string
+	seed       uint32
+	seeded     bool
+	consistent bool
+	maxItems   int
+	hash       HashFunc
+}
+
+// WithShardKey makes the sharded cache pick a key's shard by hashing
+// shardKey(k) instead of the whole key, so keys that map to the same
+// grouping string (e.g. a tenant prefix) are kept in the same shard.
+func WithShardKey(shardKey func(k string) string) ShardedOption {
+	return func(cfg *shardedConfig) {
+		cfg.shardKey = shardKey
+	}
+}
+
+// WithShardedMaxItems bounds the sharded cache to about max items by giving
+// each shard its own limit of max/shards (rounded up), enforced like
+// WithMaxItems. Each shard evicts independently under its own lock, so the
+// cache as a whole may start evicting before it holds max items when keys
+// are not spread evenly over the shards.
+func WithShardedMaxItems(max int) ShardedOption {
+	return func(cfg *shardedConfig) {
+		cfg.maxItems = max
+	}
 }
 
 // djb2 with better shuffling. 5x faster than FNV with the hash.Hash overhead.
@@ -62,8 +101,29 @@ func djb33(seed uint32, k string) uint32 {
 	return d ^ (d >> 16)
 }
 
+// WithSeed fixes the seed of the shard hash, so that keys are routed to the
+// same shards every time. By default the seed is read from the system CSPRNG,
+// which makes shard placement unpredictable to attackers; pin it only for
+// tests and debugging.
+func WithSeed(seed uint32) ShardedOption {
+	return func(cfg *shardedConfig) {
+		cfg.seed = seed
+		cfg.seeded = true
+	}
+}
+
 func (sc *shardedCache[V]) bucket(k string) *cache[string, V] {
-	return sc.cs[djb33(sc.seed, k)%sc.m]
+	return sc.cs[sc.shardIndex(k)]
+}
+
+func (sc *shardedCache[V]) shardIndex(k string) int {
+	if sc.shardKey != nil {
+		k = sc.shardKey(k)
+	}
+	if sc.ring != nil {
+		return sc.ring.shard(mix32(sc.hash(sc.seed, k)))
+	}
+	return int(sc.hash(sc.seed, k) % sc.m)
 }
 
 func (sc *shardedCache[V]) Set(k string, x V, d time.Duration) {
@@ -74,6 +134,16 @@ func (sc *shardedCache[V]) Add(k string, x V, d time.Duration) error {
 	return sc.bucket(k).Add(k, x, d)
 }
 
+// Swap sets the item for k and returns the value it replaced, like
+// Cache.Swap. The read and the write happen under the write lock of k's shard.
+func (sc *shardedCache[V]) Swap(k string, x V, d time.Duration) (old V, had bool, err error) {
+	return sc.bucket(k).Swap(k, x, d)
+}
+
+func (sc *shardedCache[V]) Contains(k string) bool {
+	return sc.bucket(k).Contains(k)
+}
+
 func (sc *shardedCache[V]) Get(k string) (V, bool) {
 	return sc.bucket(k).Get(k)
 }
@@ -86,12 +156,26 @@ func (sc *shardedCache[V]) Delete(k string) {
 	sc.bucket(k).Delete(k)
 }
 
+// Remove is an alias for Delete.
+//
+// Deprecated: Delete is the canonical name; use it instead.
+func (sc *shardedCache[V]) Remove(k string) {
+	sc.Delete(k)
+}
+
 func (sc *shardedCache[V]) DeleteExpired() {
 	for _, v := range sc.cs {
 		v.DeleteExpired()
 	}
 }
 
+// Tidy is an alias for DeleteExpired.
+//
+// Deprecated: DeleteExpired is the canonical name; use it instead.
+func (sc *shardedCache[V]) Tidy() {
+	sc.DeleteExpired()
+}
+
 // Returns the items in the cache. This may include items that have expired,
 // but have not yet been cleaned up. If this is significant, the Expiration
 // fields of the items should be checked. Note that explicit synchronization
@@ -117,39 +201,121 @@ func (sc *shardedCache[V]) Foreach(fn func(k string, v V)) {
 	}
 }
 
-func newShardedCache[V any](n int, de time.Duration) *shardedCache[V] {
-	max := big.NewInt(0).SetUint64(uint64(math.MaxUint32))
-	rnd, err := rand.Int(rand.Reader, max)
-	var seed uint32
-	if err != nil {
-		os.Stderr.Write([]byte("WARNING: go-cache's newShardedCache failed to read from the system CSPRNG (/dev/urandom or equivalent.) Your system's security may be compromised. Continuing with an insecure seed.\n"))
-		seed = insecurerand.Uint32()
-	} else {
-		seed = uint32(rnd.Uint64())
+// ShardIndexOf returns the index of the shard k is stored in, as passed to
+// ForeachShard, for correlating a problematic key with its shard's load.
+func (sc *shardedCache[V]) ShardIndexOf(k string) int {
+	return sc.shardIndex(k)
+}
+
+// Imbalance returns the number of items in the largest shard divided by the
+// mean number of items per shard. 1 means the items are spread evenly; a value
+// close to the number of shards means most items landed in a single shard.
+// It returns 0 for an empty cache.
+func (sc *shardedCache[V]) Imbalance() float64 {
+	total, largest := 0, 0
+	for _, c := range sc.cs {
+		n := c.Len()
+		total += n
+		if n > largest {
+			largest = n
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(largest) / (float64(total) / float64(len(sc.cs)))
+}
+
+// ForeachShard calls fn once per shard with a snapshot of that shard's
+// unexpired items. Each snapshot is taken under its own shard's lock only, and
+// no lock is held while fn runs.
+func (sc *shardedCache[V]) ForeachShard(fn func(shardIndex int, items map[string]V)) {
+	for i, c := range sc.cs {
+		fn(i, c.snapshot())
+	}
+}
+
+// ForeachParallel visits all items like Foreach, but each shard is visited on
+// its own goroutine, so fn is called concurrently and must be safe for
+// concurrent use. It returns once every shard has been visited.
+func (sc *shardedCache[V]) ForeachParallel(fn func(k string, v V)) {
+	var wg sync.WaitGroup
+	wg.Add(len(sc.cs))
+	for _, c := range sc.cs {
+		go func(c *cache[string, V]) {
+			c.Foreach(fn)
+			wg.Done()
+		}(c)
+	}
+	wg.Wait()
+}
+
+func newShardedCache[V any](n int, de time.Duration, cfg shardedConfig) *shardedCache[V] {
+	seed := cfg.seed
+	if !cfg.seeded {
+		max := big.NewInt(0).SetUint64(uint64(math.MaxUint32))
+		rnd, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			os.Stderr.Write([]byte("WARNING: go-cache's newShardedCache failed to read from the system CSPRNG (/dev/urandom or equivalent.) Your system's security may be compromised. Continuing with an insecure seed.\n"))
+			seed = insecurerand.Uint32()
+		} else {
+			seed = uint32(rnd.Uint64())
+		}
 	}
 	sc := &shardedCache[V]{
-		seed: seed,
-		m:    uint32(n),
-		cs:   make([]*cache[string, V], n),
-		stop: make(chan struct{}),
+		seed:     seed,
+		m:        uint32(n),
+		cs:       make([]*cache[string, V], n),
+		stop:     make(chan struct{}),
+		shardKey: cfg.shardKey,
+		hash:     cfg.hash,
+	}
+	if sc.hash == nil {
+		sc.hash = djb33
 	}
 	for i := 0; i < n; i++ {
-		c := &cache[string, V]{
-			defaultExpiration: de,
-			indices:           map[string]int{},
+		sc.cs[i] = newCache[string, V](0, de)
+		if cfg.maxItems > 0 {
+			sc.cs[i].maxItems = (cfg.maxItems + n - 1) / n
 		}
-		sc.cs[i] = c
+	}
+	if cfg.consistent {
+		sc.ring = newHashRing(seed, n)
 	}
 	return sc
 }
 
+// Close stops the sharded cache's janitor goroutine, if it has one, and
+// closes its shards, which then refuse writes as described for Cache.Close.
+// It is safe to call Close more than once.
+func (sc *shardedCache[V]) Close() {
+	sc.closeOnce.Do(func() {
+		for _, c := range sc.cs {
+			c.Close()
+		}
+		close(sc.stop)
+	})
+}
+
+// minCleanupStep is the shortest tick at which run staggers the cleanup of
+// the shards.
+const minCleanupStep = time.Millisecond
+
+// run is the sharded cache's janitor. Rather than cleaning every shard at
+// once each interval, which takes all the shard locks in one burst, it cleans
+// one shard every interval/shards, so each shard is still cleaned once per
+// interval but at a different phase from the others. See cleanupStep for
+// intervals too short to stagger.
 func (sc *shardedCache[V]) run(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	defer atomic.AddInt64(&activeJanitors, -1)
+	step, perTick := cleanupStep(interval, len(sc.cs))
+	ticker := time.NewTicker(step)
+	next := 0
 	for {
 		select {
 		case <-ticker.C:
-			for _, c := range sc.cs {
-				c.DeleteExpired()
+			for i := 0; i < perTick; i++ {
+				next = sc.cleanShard(next)
 			}
 		case <-sc.stop:
 			ticker.Stop()
@@ -158,16 +324,40 @@ func (sc *shardedCache[V]) run(interval time.Duration) {
 	}
 }
 
-func NewSharded[V any](defaultExpiration, cleanupInterval time.Duration, shards int) *ShardedCache[V] {
-	if defaultExpiration == 0 {
-		defaultExpiration = -1
+// cleanupStep returns the janitor's tick for the given cleanup interval and
+// number of shards, and how many shards to clean per tick. If staggering
+// would tick faster than minCleanupStep, all shards are cleaned together once
+// per interval instead.
+func cleanupStep(interval time.Duration, shards int) (step time.Duration, perTick int) {
+	step = interval / time.Duration(shards)
+	if step < minCleanupStep {
+		return interval, shards
+	}
+	return step, 1
+}
+
+// cleanShard deletes the expired items of shard i and returns the index of
+// the shard to clean next.
+func (sc *shardedCache[V]) cleanShard(i int) int {
+	sc.cs[i].DeleteExpired()
+	return (i + 1) % len(sc.cs)
+}
+
+func NewSharded[V any](defaultExpiration, cleanupInterval time.Duration, shards int, opts ...ShardedOption) *ShardedCache[V] {
+	if defaultExpiration <= 0 {
+		defaultExpiration = NoExpiration
+	}
+	var cfg shardedConfig
+	for _, opt := range opts {
+		opt(&cfg)
 	}
-	sc := newShardedCache[V](shards, defaultExpiration)
+	sc := newShardedCache[V](shards, defaultExpiration, cfg)
 	SC := &ShardedCache[V]{sc}
 	if cleanupInterval > 0 {
+		atomic.AddInt64(&activeJanitors, 1)
 		go sc.run(cleanupInterval)
 		runtime.SetFinalizer(SC, func(sc *ShardedCache[V]) {
-			close(sc.stop)
+			sc.Close()
 		})
 	}
 	return SC
@@ -2,7 +2,9 @@ package simplecache
 
 import (
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -33,6 +35,255 @@ func TestShardedCache(t *testing.T) {
 	}
 }
 
+func TestShardedCacheContains(t *testing.T) {
+	tc := NewSharded[string](DefaultExpiration, 0, 13)
+	for _, v := range shardedKeys {
+		tc.Set(v, "value", DefaultExpiration)
+	}
+	tc.Set("expired", "value", time.Nanosecond)
+	<-time.After(time.Millisecond)
+	for _, v := range shardedKeys {
+		if !tc.Contains(v) {
+			t.Errorf("%s was not found", v)
+		}
+	}
+	if tc.Contains("expired") || tc.Contains("missing") {
+		t.Error("an expired or missing key was found")
+	}
+}
+
+func TestShardedCacheSwap(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 4)
+	if old, had, _ := tc.Swap("a", 1, DefaultExpiration); had || old != 0 {
+		t.Error("Swap of a new key returned", old, had)
+	}
+	if old, had, _ := tc.Swap("a", 2, DefaultExpiration); !had || old != 1 {
+		t.Error("Swap of an existing key returned", old, had)
+	}
+	if v, ok := tc.Get("a"); !ok || v != 2 {
+		t.Error("Swap did not store the new value:", v, ok)
+	}
+}
+
+func TestShardedCacheSeed(t *testing.T) {
+	a := NewSharded[int](DefaultExpiration, 0, 16, WithSeed(42))
+	b := NewSharded[int](DefaultExpiration, 0, 16, WithSeed(42))
+	c := NewSharded[int](DefaultExpiration, 0, 16, WithSeed(43))
+	differ := false
+	for i := 0; i < 1000; i++ {
+		k := "key" + strconv.Itoa(i)
+		if a.shardIndex(k) != b.shardIndex(k) {
+			t.Fatalf("%s was routed to shards %d and %d with the same seed", k, a.shardIndex(k), b.shardIndex(k))
+		}
+		if a.shardIndex(k) != c.shardIndex(k) {
+			differ = true
+		}
+	}
+	if !differ {
+		t.Error("different seeds routed every key to the same shard")
+	}
+}
+
+func TestShardedCacheImbalance(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 8)
+	if n := tc.Imbalance(); n != 0 {
+		t.Error("empty cache has imbalance", n)
+	}
+	for i := 0; i < 8000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	if n := tc.Imbalance(); n < 1 || n > 1.5 {
+		t.Error("unexpected imbalance for evenly spread keys:", n)
+	}
+
+	skewed := NewSharded[int](DefaultExpiration, 0, 8, WithShardKey(func(string) string { return "" }))
+	for i := 0; i < 8000; i++ {
+		skewed.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	if n := skewed.Imbalance(); n != 8 {
+		t.Error("expected imbalance 8 when all keys share a shard, got", n)
+	}
+}
+
+func TestShardedCacheForeachShard(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 8)
+	for i := 0; i < 1000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	tc.Set("expired", -1, time.Nanosecond)
+	<-time.After(time.Millisecond)
+
+	all := make(map[string]int)
+	shards := make(map[int]bool)
+	tc.ForeachShard(func(i int, items map[string]int) {
+		shards[i] = true
+		for k, v := range items {
+			if tc.shardIndex(k) != i {
+				t.Errorf("%s was reported in shard %d", k, i)
+			}
+			all[k] = v
+		}
+	})
+	if len(shards) != 8 {
+		t.Error("not every shard was visited:", shards)
+	}
+	if len(all) != 1000 {
+		t.Fatalf("expected 1000 items across shards, got %d", len(all))
+	}
+	for i := 0; i < 1000; i++ {
+		if all[strconv.Itoa(i)] != i {
+			t.Errorf("wrong value for %d: %d", i, all[strconv.Itoa(i)])
+		}
+	}
+}
+
+func TestShardedCacheShardIndexOf(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 8)
+	for _, k := range []string{"a", "tenant:42", "a much longer key than the others"} {
+		i := tc.ShardIndexOf(k)
+		if i < 0 || i >= 8 {
+			t.Fatalf("shard index %d out of range for %q", i, k)
+		}
+		tc.Set(k, 1, DefaultExpiration)
+		tc.ForeachShard(func(shard int, items map[string]int) {
+			if _, ok := items[k]; ok != (shard == i) {
+				t.Errorf("%q: ShardIndexOf returned %d, but found in shard %d: %v", k, i, shard, ok)
+			}
+		})
+	}
+}
+
+func TestShardedCacheForeachParallel(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 8)
+	want := int64(0)
+	for i := 0; i < 1000; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+		want += int64(i)
+	}
+	var n, sum int64
+	tc.ForeachParallel(func(k string, v int) {
+		atomic.AddInt64(&n, 1)
+		atomic.AddInt64(&sum, int64(v))
+	})
+	if n != 1000 || sum != want {
+		t.Errorf("visited %d items with sum %d, expected 1000 items with sum %d", n, sum, want)
+	}
+}
+
+func TestShardedCacheShardKey(t *testing.T) {
+	tenant := func(k string) string {
+		return k[:strings.IndexByte(k, ':')]
+	}
+	tc := NewSharded[string](DefaultExpiration, 0, 64, WithShardKey(tenant))
+	for i := 0; i < 100; i++ {
+		t1, t2 := "tenant1:"+strconv.Itoa(i), "tenant2:"+strconv.Itoa(i)
+		if tc.bucket(t1) != tc.bucket("tenant1:x") || tc.bucket(t2) != tc.bucket("tenant2:x") {
+			t.Fatal("keys of the same tenant landed in different shards")
+		}
+		tc.Set(t1, "value", DefaultExpiration)
+	}
+	if n := tc.bucket("tenant1:x").Len(); n != 100 {
+		t.Errorf("expected all 100 keys in the tenant's shard, got %d", n)
+	}
+}
+
+func TestShardedCacheMaxItems(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 4, WithSeed(1), WithShardedMaxItems(7))
+	clock := newFakeClock()
+	for _, c := range tc.cs {
+		c.now = clock.now
+		if c.maxItems != 2 {
+			t.Fatal("expected a per-shard limit of 2, got", c.maxItems)
+		}
+	}
+
+	// Fill one shard past its limit
+	var same []string
+	for i := 0; len(same) < 3; i++ {
+		k := "user:" + strconv.Itoa(i)
+		if tc.shardIndex(k) == 0 {
+			same = append(same, k)
+		}
+	}
+	var other string
+	for i := 0; other == ""; i++ {
+		if k := "user:" + strconv.Itoa(i); tc.shardIndex(k) != 0 {
+			other = k
+		}
+	}
+	tc.Set(other, 0, DefaultExpiration)
+	for _, k := range same {
+		clock.Add(time.Millisecond)
+		tc.Set(k, 0, DefaultExpiration)
+	}
+
+	if tc.Contains(same[0]) {
+		t.Error("oldest key of the full shard was not evicted")
+	}
+	if !tc.Contains(same[1]) || !tc.Contains(same[2]) {
+		t.Error("newer keys of the full shard were evicted")
+	}
+	if !tc.Contains(other) {
+		t.Error("key in another shard was evicted")
+	}
+}
+
+func TestShardedCacheRemove(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 4)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Delete("a")
+	tc.Remove("b")
+	if tc.Contains("a") || tc.Contains("b") {
+		t.Error("items were not deleted:", tc.Keys())
+	}
+}
+
+func TestShardedCacheStaggeredCleanup(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 4, WithSeed(1))
+	clock := newFakeClock()
+	for _, c := range tc.cs {
+		c.now = clock.now
+	}
+	for i := 0; i < 100; i++ {
+		tc.Set("user:"+strconv.Itoa(i), i, time.Second)
+	}
+	clock.Add(2 * time.Second)
+
+	// Each janitor tick cleans only the next shard
+	next := 0
+	for tick := 0; tick < len(tc.cs); tick++ {
+		next = tc.cleanShard(next)
+		for i, c := range tc.cs {
+			if cleaned := c.Len() == 0; cleaned != (i <= tick) {
+				t.Fatalf("after tick %d, shard %d has %d items", tick, i, c.Len())
+			}
+		}
+	}
+	if next != 0 {
+		t.Error("janitor did not wrap around to the first shard:", next)
+	}
+}
+
+func TestShardedCacheCleanupStep(t *testing.T) {
+	for _, tt := range []struct {
+		interval time.Duration
+		shards   int
+		step     time.Duration
+		perTick  int
+	}{
+		{time.Minute, 4, 15 * time.Second, 1},
+		{4 * time.Millisecond, 4, time.Millisecond, 1},
+		{time.Millisecond, 4, time.Millisecond, 4},
+		{10 * time.Nanosecond, 64, 10 * time.Nanosecond, 64},
+	} {
+		step, perTick := cleanupStep(tt.interval, tt.shards)
+		if step != tt.step || perTick != tt.perTick {
+			t.Errorf("cleanupStep(%v, %d) = %v, %d, expected %v, %d", tt.interval, tt.shards, step, perTick, tt.step, tt.perTick)
+		}
+	}
+}
+
 func BenchmarkShardedCacheGetExpiring(b *testing.B) {
 	benchmarkShardedCacheGet(b, 5*time.Minute)
 }
@@ -83,3 +334,12 @@ func benchmarkShardedCacheGetManyConcurrent(b *testing.B, exp time.Duration) {
 	b.StartTimer()
 	wg.Wait()
 }
+
+func TestShardedCacheWriteAfterClose(t *testing.T) {
+	tc := NewSharded[int](NoExpiration, 0, 4)
+	tc.Close()
+	tc.Set("a", 1, DefaultExpiration)
+	if _, ok := tc.Get("a"); ok {
+		t.Error("Set stored an item after Close")
+	}
+}
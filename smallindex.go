@@ -0,0 +1,60 @@
+package simplecache
+
+// WithLinearScan makes the cache find items by scanning them in order, rather
+// than through its index map, while it holds at most n items. For a handful of
+// keys a scan is cheaper than hashing, and the cache needs no map at all,
+// which adds up for programs that keep many small caches. The map is built
+// once the cache grows past n items, and dropped again once it shrinks to
+// n/2, so a cache hovering around n does not rebuild it on every write.
+// Lookups in a large cache cost one extra nil check.
+func WithLinearScan[K comparable, V any](n int) Option[K, V] {
+	return func(c *cache[K, V]) {
+		if n > 0 {
+			c.scanMax = n
+			c.indices = nil
+		}
+	}
+}
+
+// index returns the position of k in items. The caller must hold the lock.
+func (c *cache[K, V]) index(k K) (int, bool) {
+	if c.indices != nil {
+		idx, found := c.indices[k]
+		return idx, found
+	}
+	return c.scan(k)
+}
+
+// scan finds k by comparing it with every key in items.
+func (c *cache[K, V]) scan(k K) (int, bool) {
+	for i := range c.items {
+		if c.items[i].key == k {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// indexAppended records that k was appended to items, building the index map
+// if the cache has outgrown linear scans. The caller must hold the write lock.
+func (c *cache[K, V]) indexAppended(k K) {
+	if c.indices != nil {
+		c.indices[k] = len(c.items) - 1
+		return
+	}
+	if len(c.items) > c.scanMax {
+		c.indices = make(map[K]int, len(c.items))
+		for i := range c.items {
+			c.indices[c.items[i].key] = i
+		}
+	}
+}
+
+// newIndex returns the index map for an empty cache, sized for initcap items
+// like items is, or nil if the cache starts with linear scans.
+func (c *cache[K, V]) newIndex() map[K]int {
+	if c.scanMax > 0 {
+		return nil
+	}
+	return make(map[K]int, c.initcap)
+}
@@ -0,0 +1,68 @@
+package simplecache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestLinearScan(t *testing.T) {
+	tc := New(0, NoExpiration, 0, WithLinearScan[string, int](4))
+	for i := 0; i < 4; i++ {
+		tc.Set(strconv.Itoa(i), i, DefaultExpiration)
+	}
+	if tc.indices != nil {
+		t.Fatal("index map built for 4 items")
+	}
+	tc.Set("4", 4, DefaultExpiration)
+	if tc.indices == nil {
+		t.Fatal("index map not built for 5 items")
+	}
+	for i := 0; i < 5; i++ {
+		if v, ok := tc.Get(strconv.Itoa(i)); !ok || v != i {
+			t.Errorf("got %d, %v for %d", v, ok, i)
+		}
+	}
+
+	tc.Delete("0")
+	tc.Delete("4")
+	if tc.indices == nil {
+		t.Fatal("index map dropped at 3 items")
+	}
+	tc.Delete("2")
+	if tc.indices != nil {
+		t.Fatal("index map kept at 2 items")
+	}
+	if _, ok := tc.Get("2"); ok {
+		t.Error("found deleted item")
+	}
+	for _, k := range []string{"1", "3"} {
+		if _, ok := tc.Get(k); !ok {
+			t.Error("did not find", k)
+		}
+	}
+	tc.Purge()
+	if tc.indices != nil || tc.Len() != 0 {
+		t.Error("Purge did not reset the cache to linear scans")
+	}
+}
+
+func benchmarkSmallGet(b *testing.B, n int, opts ...Option[string, int]) {
+	b.StopTimer()
+	tc := New(n, NoExpiration, 0, opts...)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		tc.Set(keys[i], i, DefaultExpiration)
+	}
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		tc.Get(keys[i%n])
+	}
+}
+
+func BenchmarkSmallGetMap4(b *testing.B)   { benchmarkSmallGet(b, 4) }
+func BenchmarkSmallGetMap8(b *testing.B)   { benchmarkSmallGet(b, 8) }
+func BenchmarkSmallGetMap16(b *testing.B)  { benchmarkSmallGet(b, 16) }
+func BenchmarkSmallGetScan4(b *testing.B)  { benchmarkSmallGet(b, 4, WithLinearScan[string, int](16)) }
+func BenchmarkSmallGetScan8(b *testing.B)  { benchmarkSmallGet(b, 8, WithLinearScan[string, int](16)) }
+func BenchmarkSmallGetScan16(b *testing.B) { benchmarkSmallGet(b, 16, WithLinearScan[string, int](16)) }
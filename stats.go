@@ -0,0 +1,127 @@
+package simplecache
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ttlBuckets are the upper bounds of the TTL histogram buckets.
+var ttlBuckets = []time.Duration{
+	time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+}
+
+// TTLOverflow is the TTLHistogram bucket counting durations longer than the
+// largest bucket bound (24 hours).
+const TTLOverflow = time.Duration(math.MaxInt64)
+
+// WithTTLHistogram makes the cache count the durations its items are written
+// with in a histogram, see TTLHistogram. Recording costs one atomic increment
+// per write.
+func WithTTLHistogram[K comparable, V any]() Option[K, V] {
+	return func(c *cache[K, V]) {
+		c.ttlHist = make([]int64, len(ttlBuckets)+2)
+	}
+}
+
+// recordExpiration counts a write made at now with the absolute expiration e
+// in the TTL histogram. It is only called once the write has succeeded.
+func (c *cache[K, V]) recordExpiration(e, now int64) {
+	var d time.Duration // never expires
+	if e > 0 {
+		d = time.Duration(e - now)
+		if d <= 0 {
+			d = 1 // already expired, count it in the shortest bucket
+		}
+	}
+	c.recordTTL(d)
+}
+
+// recordTTL counts the resolved duration d of a write in the TTL histogram.
+func (c *cache[K, V]) recordTTL(d time.Duration) {
+	i := len(ttlBuckets) + 1 // NoExpiration
+	if d > 0 {
+		i = len(ttlBuckets) // TTLOverflow
+		for j, b := range ttlBuckets {
+			if d <= b {
+				i = j
+				break
+			}
+		}
+	}
+	atomic.AddInt64(&c.ttlHist[i], 1)
+}
+
+// TTLHistogram returns how many writes used each range of durations, after
+// DefaultExpiration and WithMaxTTL have been applied. Each key is the
+// inclusive upper bound of its bucket (1s, 1m, 10m, 1h and 24h); longer
+// durations are counted under TTLOverflow and items that never expire under
+// NoExpiration. It returns nil unless the cache was created with
+// WithTTLHistogram.
+func (c *cache[K, V]) TTLHistogram() map[time.Duration]int64 {
+	if c.ttlHist == nil {
+		return nil
+	}
+	h := make(map[time.Duration]int64, len(c.ttlHist))
+	for i, b := range ttlBuckets {
+		h[b] = atomic.LoadInt64(&c.ttlHist[i])
+	}
+	h[TTLOverflow] = atomic.LoadInt64(&c.ttlHist[len(ttlBuckets)])
+	h[NoExpiration] = atomic.LoadInt64(&c.ttlHist[len(ttlBuckets)+1])
+	return h
+}
+
+// ExpirationHistogram counts the unexpired items in the cache by the time they
+// have left to live. buckets holds the inclusive upper bounds of the buckets
+// in ascending order; the returned slice has one more element than buckets,
+// counting the items that outlive the largest bound or never expire.
+func (c *cache[K, V]) ExpirationHistogram(buckets []time.Duration) []int {
+	counts := make([]int, len(buckets)+1)
+	c.RLock()
+	now := c.now()
+	for i := range c.items {
+		item := &c.items[i]
+		if !c.alive(item, now) {
+			continue
+		}
+		j := len(buckets)
+		if item.Expiration > 0 {
+			ttl := time.Duration(item.Expiration - now)
+			for b, bound := range buckets {
+				if ttl <= bound {
+					j = b
+					break
+				}
+			}
+		}
+		counts[j]++
+	}
+	c.RUnlock()
+	return counts
+}
+
+// PeakLen returns the largest number of items the cache has held at once
+// since it was created or ResetStats was last called. Like Len, it counts
+// expired items that had not been cleaned up yet. Compared with a capacity
+// limit, it shows whether the cache ever came close to it.
+func (c *cache[K, V]) PeakLen() int {
+	c.RLock()
+	n := c.peak
+	c.RUnlock()
+	return n
+}
+
+// ResetStats restarts the statistics of the cache: PeakLen starts again from
+// the current number of items, and the TTLHistogram counts from zero.
+func (c *cache[K, V]) ResetStats() {
+	c.Lock()
+	c.peak = len(c.items)
+	c.Unlock()
+	for i := range c.ttlHist {
+		atomic.StoreInt64(&c.ttlHist[i], 0)
+	}
+}
@@ -0,0 +1,108 @@
+package simplecache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLHistogram(t *testing.T) {
+	if h := New[string, int](0, DefaultExpiration, 0).TTLHistogram(); h != nil {
+		t.Error("histogram is recorded without WithTTLHistogram:", h)
+	}
+
+	tc := New(100, 5*time.Minute, 0, WithTTLHistogram[string, int]())
+	tc.Set("a", 1, 500*time.Millisecond)
+	tc.Set("b", 1, time.Second)
+	tc.Set("c", 1, 30*time.Second)
+	tc.Set("d", 1, DefaultExpiration)
+	tc.Set("e", 1, 2*time.Hour)
+	tc.Set("f", 1, 48*time.Hour)
+	tc.Set("g", 1, NoExpiration)
+	tc.Add("h", 1, NoExpiration)
+
+	want := map[time.Duration]int64{
+		time.Second:      2,
+		time.Minute:      1,
+		10 * time.Minute: 1,
+		time.Hour:        0,
+		24 * time.Hour:   1,
+		TTLOverflow:      1,
+		NoExpiration:     2,
+	}
+	h := tc.TTLHistogram()
+	if len(h) != len(want) {
+		t.Fatal("unexpected buckets:", h)
+	}
+	for b, n := range want {
+		if h[b] != n {
+			t.Errorf("bucket %v: got %d, expected %d", b, h[b], n)
+		}
+	}
+}
+
+func TestTTLHistogramRefusedWrites(t *testing.T) {
+	tc := New(100, DefaultExpiration, 0, WithTTLHistogram[string, int](),
+		WithMaxItems[string, int](1), WithOverflowPolicy[string, int](RejectNew))
+	tc.Set("a", 1, time.Hour)
+	tc.Add("a", 1, time.Hour)
+	tc.Set("b", 1, time.Hour)
+	tc.Close()
+	tc.Set("a", 1, time.Hour)
+	if n := tc.TTLHistogram()[time.Hour]; n != 1 {
+		t.Error("expected only the successful write to be counted, got", n)
+	}
+}
+
+func TestExpirationHistogram(t *testing.T) {
+	clock := newFakeClock()
+	tc := New[string, int](10, NoExpiration, 0)
+	tc.now = clock.now
+	tc.Set("a", 1, 500*time.Millisecond)
+	tc.Set("b", 1, time.Second)
+	tc.Set("c", 1, 30*time.Second)
+	tc.Set("d", 1, 2*time.Minute)
+	tc.Set("e", 1, time.Hour)
+	tc.Set("f", 1, NoExpiration)
+	tc.Set("g", 1, 100*time.Millisecond)
+	clock.Add(200 * time.Millisecond)
+
+	got := tc.ExpirationHistogram([]time.Duration{time.Second, time.Minute, 10 * time.Minute})
+	want := []int{2, 1, 1, 2}
+	if len(got) != len(want) {
+		t.Fatalf("got %d buckets, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("bucket %d: got %d, expected %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPeakLen(t *testing.T) {
+	tc := New(10, NoExpiration, 0, WithTTLHistogram[int, int]())
+	for i := 0; i < 5; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	for i := 0; i < 3; i++ {
+		tc.Delete(i)
+	}
+	tc.Set(0, 0, DefaultExpiration)
+	if n := tc.PeakLen(); n != 5 {
+		t.Errorf("got peak %d, expected 5", n)
+	}
+	if n := tc.Len(); n != 3 {
+		t.Errorf("got %d items, expected 3", n)
+	}
+
+	tc.ResetStats()
+	if n := tc.PeakLen(); n != 3 {
+		t.Errorf("got peak %d after ResetStats, expected the current 3", n)
+	}
+	if h := tc.TTLHistogram(); h[NoExpiration] != 0 {
+		t.Error("ResetStats did not reset the TTL histogram:", h)
+	}
+	tc.Set(10, 10, DefaultExpiration)
+	if n := tc.PeakLen(); n != 4 {
+		t.Errorf("got peak %d, expected 4", n)
+	}
+}
@@ -0,0 +1,67 @@
+package simplecache
+
+import "time"
+
+// SetWithTags adds an item to the cache like Set and attaches tags to it, so
+// that it can later be removed together with every other item carrying one of
+// the tags by InvalidateTag. Any tags the key had before are replaced; setting
+// the key again without tags clears them.
+func (c *cache[K, V]) SetWithTags(k K, x V, d time.Duration, tags ...string) {
+	e := c.expiration(d)
+	c.Lock()
+	if c.setExpiration(k, x, e) == nil && len(tags) > 0 {
+		if c.keyTags == nil {
+			c.tags = make(map[string]map[K]struct{})
+			c.keyTags = make(map[K][]string)
+		}
+		for _, tag := range tags {
+			ks := c.tags[tag]
+			if ks == nil {
+				ks = make(map[K]struct{})
+				c.tags[tag] = ks
+			}
+			ks[k] = struct{}{}
+		}
+		c.keyTags[k] = append([]string(nil), tags...)
+	}
+	c.unlock()
+}
+
+// InvalidateTag deletes every item carrying tag and returns the number of
+// unexpired items that were removed. The onEvicted callback is called for all
+// deleted items.
+func (c *cache[K, V]) InvalidateTag(tag string) int {
+	var ks []K
+	var vs []V
+	n := 0
+	c.Lock()
+	now := c.now()
+	for k := range c.tags[tag] {
+		if _, ok := c.lookup(k, now); ok {
+			n++
+		}
+		ks = append(ks, k)
+	}
+	for _, k := range ks {
+		if v, evicted := c.delete(k); evicted {
+			vs = append(vs, v)
+		}
+	}
+	c.Unlock()
+	for i := range vs {
+		c.notifyEvicted(c.onEvicted, ks[i], vs[i])
+	}
+	return n
+}
+
+// untag removes k from the tag index. The caller must hold the write lock.
+func (c *cache[K, V]) untag(k K) {
+	for _, tag := range c.keyTags[k] {
+		ks := c.tags[tag]
+		delete(ks, k)
+		if len(ks) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, k)
+}
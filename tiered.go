@@ -0,0 +1,153 @@
+package simplecache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Tiered is a small, fixed-size LRU cache of hot items ("L1") in front of a
+// Cache ("L2"). Get checks L1 first and only falls back to L2 on a miss,
+// promoting the item into L1, so the hottest keys skip L2's decoding and
+// copying of values. Set and Delete write through to both tiers.
+//
+// Items in L1 keep the expiration they had in L2, and an L1 hit is only
+// served while the item is still alive in L2, so items L2 drops (when it is
+// invalidated, evicted to make room or idle) are not served from L1 either.
+// Values written directly to the L2 cache are not seen by L1 until the item
+// is evicted from it, so all writes should go through the Tiered cache.
+type Tiered[K comparable, V any] struct {
+	mu     sync.Mutex
+	size   int
+	ll     *list.List
+	items  map[K]*list.Element
+	l2     *Cache[K, V]
+	misses uint64
+	writes uint64 // bumped by Set and Delete, see Get
+}
+
+type tieredEntry[K comparable, V any] struct {
+	key        K
+	value      V
+	expiration int64
+}
+
+// NewTiered returns a Tiered cache holding up to l1Size items in L1 in front
+// of l2.
+func NewTiered[K comparable, V any](l1Size int, l2 *Cache[K, V]) *Tiered[K, V] {
+	if l1Size < 1 {
+		l1Size = 1
+	}
+	return &Tiered[K, V]{
+		size:  l1Size,
+		ll:    list.New(),
+		items: make(map[K]*list.Element, l1Size),
+		l2:    l2,
+	}
+}
+
+// Get an item from L1, or from L2 if it is not in L1. Returns the item and a
+// bool indicating whether the key was found.
+// The item is not promoted into L1 if a Set or Delete ran while it was read
+// from L2.
+func (t *Tiered[K, V]) Get(k K) (v V, ok bool) {
+	t.mu.Lock()
+	if el, found := t.items[k]; found {
+		e := el.Value.(*tieredEntry[K, V])
+		now := t.l2.now()
+		if (e.expiration == 0 || now <= e.expiration) && t.inL2(k, now) {
+			t.ll.MoveToFront(el)
+			v = e.value
+			t.mu.Unlock()
+			return v, true
+		}
+		t.removeElement(el)
+	}
+	t.misses++
+	writes := t.writes
+	t.mu.Unlock()
+
+	v, exp, ok := t.l2.GetWithExpiration(k)
+	if !ok {
+		return v, false
+	}
+	var e int64
+	if !exp.IsZero() {
+		e = exp.UnixNano()
+	}
+	t.mu.Lock()
+	// A Set or Delete made while L2 was read may have replaced or removed
+	// the value; promoting it then would serve a stale value from L1.
+	if t.writes == writes {
+		t.add(k, v, e)
+	}
+	t.mu.Unlock()
+	return v, true
+}
+
+// inL2 reports whether k is still alive in L2, recording the read there so
+// that hits served from L1 keep the item from going idle. The caller must
+// hold t.mu.
+func (t *Tiered[K, V]) inL2(k K, now int64) bool {
+	t.l2.RLock()
+	idx, ok := t.l2.lookup(k, now)
+	if ok {
+		t.l2.recordAccess(&t.l2.items[idx], now)
+	}
+	t.l2.RUnlock()
+	return ok
+}
+
+// Set an item in both tiers, replacing any existing item. The duration is
+// interpreted as in Cache.Set.
+func (t *Tiered[K, V]) Set(k K, x V, d time.Duration) {
+	e := t.l2.expiration(d)
+	t.mu.Lock()
+	t.writes++
+	t.l2.Lock()
+	err := t.l2.setExpiration(k, x, e)
+	t.l2.unlock()
+	if err == nil {
+		t.add(k, x, e)
+	}
+	t.mu.Unlock()
+}
+
+// Delete an item from both tiers.
+func (t *Tiered[K, V]) Delete(k K) {
+	t.mu.Lock()
+	t.writes++
+	if el, found := t.items[k]; found {
+		t.removeElement(el)
+	}
+	t.l2.Delete(k)
+	t.mu.Unlock()
+}
+
+// Remove is an alias for Delete.
+//
+// Deprecated: Delete is the canonical name; use it instead.
+func (t *Tiered[K, V]) Remove(k K) {
+	t.Delete(k)
+}
+
+// add stores an item in L1, evicting the least recently used item if L1 is
+// full. The caller must hold t.mu.
+func (t *Tiered[K, V]) add(k K, x V, e int64) {
+	if el, found := t.items[k]; found {
+		ent := el.Value.(*tieredEntry[K, V])
+		ent.value = x
+		ent.expiration = e
+		t.ll.MoveToFront(el)
+		return
+	}
+	if t.ll.Len() >= t.size {
+		t.removeElement(t.ll.Back())
+	}
+	t.items[k] = t.ll.PushFront(&tieredEntry[K, V]{key: k, value: x, expiration: e})
+}
+
+func (t *Tiered[K, V]) removeElement(el *list.Element) {
+	t.ll.Remove(el)
+	delete(t.items, el.Value.(*tieredEntry[K, V]).key)
+}
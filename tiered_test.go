@@ -0,0 +1,192 @@
+package simplecache
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTiered(t *testing.T) {
+	l2 := New[string, int](100, DefaultExpiration, 0)
+	tc := NewTiered(2, l2)
+
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+	tc.Set("c", 3, DefaultExpiration)
+	if _, found := tc.items["a"]; found {
+		t.Error("a was not evicted from L1")
+	}
+	if v, ok := tc.Get("a"); !ok || v != 1 {
+		t.Error("a was not found in L2:", v, ok)
+	}
+	if _, found := tc.items["a"]; !found {
+		t.Error("a was not promoted into L1")
+	}
+	if _, found := tc.items["b"]; found {
+		t.Error("b was not evicted from L1 by the promotion of a")
+	}
+
+	tc.Delete("a")
+	if _, ok := tc.Get("a"); ok {
+		t.Error("a was found after Delete")
+	}
+	if _, ok := l2.Get("a"); ok {
+		t.Error("a was not deleted from L2")
+	}
+}
+
+func TestTieredExpiration(t *testing.T) {
+	l2 := New[string, int](100, DefaultExpiration, 0)
+	clock := newFakeClock()
+	l2.now = clock.now
+	tc := NewTiered(10, l2)
+
+	tc.Set("a", 1, time.Second)
+	l2.Set("b", 2, time.Second)
+	if _, ok := tc.Get("b"); !ok {
+		t.Fatal("b was not found")
+	}
+	clock.Add(2 * time.Second)
+	if _, ok := tc.Get("a"); ok {
+		t.Error("expired a was served from L1")
+	}
+	if _, ok := tc.Get("b"); ok {
+		t.Error("expired b was served from L1 after promotion")
+	}
+}
+
+func TestTieredConcurrentWrite(t *testing.T) {
+	l2 := New[string, int](100, DefaultExpiration, 0)
+	tc := NewTiered(10, l2)
+	clock := newFakeClock()
+
+	// While the Get below reads the old value from L2, the clock hook starts
+	// a write to the same key, which lands once L2's read lock is released
+	// and before Get takes the L1 lock again.
+	var write func()
+	var armed int32
+	done := make(chan struct{})
+	l2.now = func() int64 {
+		if atomic.CompareAndSwapInt32(&armed, 1, 0) {
+			go func() {
+				write()
+				close(done)
+			}()
+			time.Sleep(10 * time.Millisecond)
+		}
+		return clock.now()
+	}
+	for _, tt := range []struct {
+		name  string
+		write func()
+		want  bool
+	}{
+		{"Set", func() { tc.Set("a", 2, time.Hour) }, true},
+		{"Delete", func() { tc.Delete("a") }, false},
+	} {
+		tc.Set("a", 1, time.Hour)
+		tc.mu.Lock()
+		tc.removeElement(tc.items["a"])
+		tc.mu.Unlock()
+
+		write, done = tt.write, make(chan struct{})
+		atomic.StoreInt32(&armed, 1)
+		tc.Get("a")
+		<-done
+		v, ok := tc.Get("a")
+		if ok != tt.want || (ok && v != 2) {
+			t.Errorf("%s during Get: got %d, %v", tt.name, v, ok)
+		}
+	}
+}
+
+func BenchmarkTieredGetSkewed(b *testing.B) {
+	l2 := New[int, int](1000, DefaultExpiration, 0)
+	tc := NewTiered(16, l2)
+	for i := 0; i < 1000; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	keys := skewedKeys(b.N)
+	tc.misses = 0
+	b.ResetTimer()
+	for _, k := range keys {
+		tc.Get(k)
+	}
+	b.ReportMetric(float64(tc.misses)/float64(b.N), "l2-gets/op")
+}
+
+func BenchmarkCacheGetSkewed(b *testing.B) {
+	tc := New[int, int](1000, DefaultExpiration, 0)
+	for i := 0; i < 1000; i++ {
+		tc.Set(i, i, DefaultExpiration)
+	}
+	keys := skewedKeys(b.N)
+	b.ResetTimer()
+	for _, k := range keys {
+		tc.Get(k)
+	}
+}
+
+// skewedKeys returns n keys in [0, 1000) following a Zipf distribution.
+func skewedKeys(n int) []int {
+	z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.2, 1, 999)
+	keys := make([]int, n)
+	for i := range keys {
+		keys[i] = int(z.Uint64())
+	}
+	return keys
+}
+
+func TestTieredPromotionRecordsAccess(t *testing.T) {
+	l2 := New(100, NoExpiration, 0, WithIdleExpiration[string, int](time.Minute))
+	clock := newFakeClock()
+	l2.now = clock.now
+	tc := NewTiered(1, l2)
+	tc.Set("a", 1, DefaultExpiration)
+	tc.Set("b", 2, DefaultExpiration)
+
+	clock.Add(40 * time.Second)
+	if _, ok := tc.Get("a"); !ok {
+		t.Fatal("a was not found in L2")
+	}
+	clock.Add(40 * time.Second)
+	if !l2.Contains("a") {
+		t.Error("reading a from L2 did not count as an access there")
+	}
+}
+
+func TestTieredL2Drops(t *testing.T) {
+	l2 := New(100, NoExpiration, 0, WithMaxItems[string, int](2), WithIdleExpiration[string, int](time.Minute))
+	clock := newFakeClock()
+	l2.now = clock.now
+	tc := NewTiered(10, l2)
+
+	tc.Set("a", 1, DefaultExpiration)
+	l2.Invalidate()
+	l2.DeleteExpired()
+	if _, ok := tc.Get("a"); ok {
+		t.Error("a was served from L1 after L2 was invalidated")
+	}
+
+	tc.Set("b", 2, DefaultExpiration)
+	clock.Add(time.Second)
+	tc.Set("c", 3, DefaultExpiration)
+	clock.Add(time.Second)
+	tc.Set("d", 4, DefaultExpiration)
+	if _, ok := tc.Get("b"); ok {
+		t.Error("b was served from L1 after L2 evicted it to make room")
+	}
+
+	clock.Add(50 * time.Second)
+	if _, ok := tc.Get("c"); !ok {
+		t.Fatal("c was not found")
+	}
+	clock.Add(50 * time.Second)
+	if _, ok := tc.Get("c"); !ok {
+		t.Error("hits on c served from L1 did not keep it from going idle in L2")
+	}
+	if _, ok := tc.Get("d"); ok {
+		t.Error("d was served from L1 after it went idle in L2")
+	}
+}
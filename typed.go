@@ -0,0 +1,17 @@
+package simplecache
+
+// GetTyped gets an item from a cache holding interface{} values and asserts it
+// to T. It returns the zero value of T and false if the key is not found or
+// the item is not a T, rather than panicking like a plain type assertion.
+func GetTyped[T any, K comparable](c *Cache[K, any], k K) (T, bool) {
+	var zero T
+	x, ok := c.Get(k)
+	if !ok {
+		return zero, false
+	}
+	v, ok := x.(T)
+	if !ok {
+		return zero, false
+	}
+	return v, true
+}
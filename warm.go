@@ -0,0 +1,71 @@
+package simplecache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// WarmError is returned by Warm and WarmParallel when the loader failed for
+// some keys. Errs holds one error per failed key, in the order of the keys
+// passed in.
+type WarmError struct {
+	Errs []error
+}
+
+func (e *WarmError) Error() string {
+	msgs := make([]string, len(e.Errs))
+	for i, err := range e.Errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("simplecache: warming failed for %d keys: %s", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// Warm populates the cache by calling loader for each key in turn and storing
+// the results with the default expiration. Keys for which loader returns an
+// error are skipped; once every key has been tried, their errors are returned
+// together as a *WarmError.
+func (c *cache[K, V]) Warm(keys []K, loader func(K) (V, error)) error {
+	return c.WarmParallel(keys, loader, 1)
+}
+
+// WarmParallel is like Warm, but calls loader from up to workers goroutines at
+// once.
+func (c *cache[K, V]) WarmParallel(keys []K, loader func(K) (V, error), workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, len(keys))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(keys); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				v, err := loader(keys[i])
+				if err != nil {
+					errs[i] = fmt.Errorf("key %v: %w", keys[i], err)
+					continue
+				}
+				c.Set(keys[i], v, DefaultExpiration)
+			}
+		}()
+	}
+	for i := range keys {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if failed != nil {
+		return &WarmError{Errs: failed}
+	}
+	return nil
+}
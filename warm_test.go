@@ -0,0 +1,72 @@
+package simplecache
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+var errOdd = errors.New("odd key")
+
+func loadEven(k int) (string, error) {
+	if k%2 == 1 {
+		return "", errOdd
+	}
+	return strconv.Itoa(k), nil
+}
+
+func TestWarm(t *testing.T) {
+	tc := New[int, string](100, DefaultExpiration, 0)
+	err := tc.Warm([]int{0, 1, 2, 3, 4}, loadEven)
+	var werr *WarmError
+	if !errors.As(err, &werr) {
+		t.Fatal("expected a *WarmError, got", err)
+	}
+	if len(werr.Errs) != 2 || !errors.Is(werr.Errs[0], errOdd) || werr.Errs[0].Error() != "key 1: odd key" {
+		t.Error("unexpected errors:", werr.Errs)
+	}
+	if tc.Len() != 3 {
+		t.Error("expected 3 items, got", tc.Len())
+	}
+	if x, found := tc.Get(4); !found || x != "4" {
+		t.Error("4 was not warmed:", x, found)
+	}
+
+	if err := tc.Warm([]int{6, 8}, loadEven); err != nil {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestWarmParallel(t *testing.T) {
+	tc := New[int, string](100, DefaultExpiration, 0)
+	keys := make([]int, 100)
+	for i := range keys {
+		keys[i] = i
+	}
+	var running, maxRunning int32
+	err := tc.WarmParallel(keys, func(k int) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		return loadEven(k)
+	}, 4)
+	var werr *WarmError
+	if !errors.As(err, &werr) || len(werr.Errs) != 50 {
+		t.Fatal("expected 50 errors, got", err)
+	}
+	if werr.Errs[0].Error() != "key 1: odd key" || werr.Errs[49].Error() != "key 99: odd key" {
+		t.Error("errors are not in key order")
+	}
+	if tc.Len() != 50 {
+		t.Error("expected 50 items, got", tc.Len())
+	}
+	if m := atomic.LoadInt32(&maxRunning); m > 4 {
+		t.Error("loader ran on more than 4 goroutines:", m)
+	}
+}
@@ -0,0 +1,65 @@
+package simplecache
+
+// Watch returns a channel that receives the new value every time k is set,
+// and a function that stops the watch and closes the channel. The channel is
+// also closed when k is deleted, which for an expired item happens when the
+// janitor or DeleteExpired removes it.
+//
+// Values are delivered without blocking the writer: the channel holds only
+// the latest value, and a value the watcher has not received yet is replaced
+// by a newer one. The current value of k, if any, is not sent.
+func (c *cache[K, V]) Watch(k K) (<-chan V, func()) {
+	ch := make(chan V, 1)
+	c.Lock()
+	if c.watchers == nil {
+		c.watchers = make(map[K][]chan V)
+	}
+	c.watchers[k] = append(c.watchers[k], ch)
+	c.Unlock()
+
+	return ch, func() {
+		c.Lock()
+		ws := c.watchers[k]
+		for i, w := range ws {
+			if w == ch {
+				ws[i] = ws[len(ws)-1]
+				ws = ws[:len(ws)-1]
+				if len(ws) == 0 {
+					delete(c.watchers, k)
+				} else {
+					c.watchers[k] = ws
+				}
+				close(ch)
+				break
+			}
+		}
+		c.Unlock()
+	}
+}
+
+// notifyWatchers sends x to the watchers of k, replacing any value they have
+// not received yet. The caller must hold the write lock, which makes it the
+// only sender.
+func (c *cache[K, V]) notifyWatchers(k K, x V) {
+	for _, ch := range c.watchers[k] {
+		select {
+		case ch <- x:
+			continue
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- x
+	}
+}
+
+// closeWatchers closes and removes the watchers of k. The caller must hold
+// the write lock.
+func (c *cache[K, V]) closeWatchers(k K) {
+	for _, ch := range c.watchers[k] {
+		close(ch)
+	}
+	delete(c.watchers, k)
+}
@@ -0,0 +1,75 @@
+package simplecache
+
+import "math/bits"
+
+// HashFunc hashes a key to pick its shard in a sharded cache. seed is the
+// sharded cache's seed (see WithSeed) and should be mixed into the hash, so
+// that shard placement cannot be predicted without it.
+type HashFunc func(seed uint32, k string) uint32
+
+// WithHashFunc makes the sharded cache pick shards with h instead of the
+// default djb33, a variant of djb2. djb33 is fast on short keys, but long
+// keys that differ in only a few bytes can land in the same shards more often
+// than chance; XXHash32 spreads those better, and is also faster on them since
+// it consumes four bytes at a time.
+func WithHashFunc(h HashFunc) ShardedOption {
+	return func(cfg *shardedConfig) {
+		cfg.hash = h
+	}
+}
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+// XXHash32 is a HashFunc computing the 32-bit xxHash of k, see
+// https://github.com/Cyan4973/xxHash.
+func XXHash32(seed uint32, k string) uint32 {
+	n := len(k)
+	var h uint32
+	if n >= 16 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(k) >= 16 {
+			v1 = xxRound(v1, le32(k))
+			v2 = xxRound(v2, le32(k[4:]))
+			v3 = xxRound(v3, le32(k[8:]))
+			v4 = xxRound(v4, le32(k[12:]))
+			k = k[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint32(n)
+	for ; len(k) >= 4; k = k[4:] {
+		h += le32(k) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+	}
+	for i := 0; i < len(k); i++ {
+		h += uint32(k[i]) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxRound(acc, in uint32) uint32 {
+	return bits.RotateLeft32(acc+in*xxPrime2, 13) * xxPrime1
+}
+
+// le32 reads the first four bytes of s as a little-endian integer.
+func le32(s string) uint32 {
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24
+}
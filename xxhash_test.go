@@ -0,0 +1,50 @@
+package simplecache
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestXXHash32(t *testing.T) {
+	for _, tt := range []struct {
+		k    string
+		want uint32
+	}{
+		{"", 0x02cc5d05},
+		{"a", 0x550d7456},
+		{"abc", 0x32d153ff},
+		{"Nobody inspects the spammish repetition", 0xe2293b2f},
+	} {
+		if h := XXHash32(0, tt.k); h != tt.want {
+			t.Errorf("XXHash32(%q) = %#x, expected %#x", tt.k, h, tt.want)
+		}
+	}
+}
+
+func TestShardedCacheHashFunc(t *testing.T) {
+	tc := NewSharded[int](DefaultExpiration, 0, 16, WithSeed(1), WithHashFunc(XXHash32))
+	for i := 0; i < 10000; i++ {
+		tc.Set("session:eu-west-1:user:"+strconv.Itoa(i)+":profile", i, DefaultExpiration)
+	}
+	if n := tc.Imbalance(); n > 1.2 {
+		t.Errorf("keys are spread unevenly over shards: imbalance %.2f", n)
+	}
+	k := "session:eu-west-1:user:42:profile"
+	if i := tc.ShardIndexOf(k); i != int(XXHash32(1, k)%16) {
+		t.Errorf("%q is in shard %d, expected the one picked by XXHash32", k, i)
+	}
+}
+
+func BenchmarkDJB33(b *testing.B) {
+	k := "session:eu-west-1:user:12345:profile"
+	for i := 0; i < b.N; i++ {
+		djb33(1, k)
+	}
+}
+
+func BenchmarkXXHash32(b *testing.B) {
+	k := "session:eu-west-1:user:12345:profile"
+	for i := 0; i < b.N; i++ {
+		XXHash32(1, k)
+	}
+}